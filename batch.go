@@ -0,0 +1,299 @@
+package squaresql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BatchResult summarises a batch executed by ExecBatch.
+type BatchResult struct {
+	// RowsAffected is the sum of the rows affected by every statement.
+	RowsAffected int64
+	// Statements is the number of statements executed.
+	Statements int
+}
+
+// BatchError is returned by ExecBatch when a statement of a batch fails. The
+// transaction is rolled back, so none of the rows were written.
+type BatchError struct {
+	Name string
+	// Row is the index of the first argument set of the failed statement and
+	// Rows the number of argument sets it covered.
+	Row  int
+	Rows int
+	Err  error
+}
+
+func (e *BatchError) Error() string {
+	if e.Rows > 1 {
+		return fmt.Sprintf("squaresql: batch '%s' failed at rows %d-%d: %v", e.Name, e.Row, e.Row+e.Rows-1, e.Err)
+	}
+	return fmt.Sprintf("squaresql: batch '%s' failed at row %d: %v", e.Name, e.Row, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// BatchOption configures ExecBatch.
+type BatchOption func(*batch)
+
+type batch struct {
+	multiRow  bool
+	maxParams int
+	txOptions *sql.TxOptions
+}
+
+// WithMultiRowValues makes ExecBatch rewrite the VALUES tuple of an INSERT
+// to insert as many argument sets per statement as fit in maxParams bind
+// parameters. A maxParams of zero uses the limit of the dialect. All bind
+// parameters of the query must appear in its VALUES tuple.
+func WithMultiRowValues(maxParams int) BatchOption {
+	return func(b *batch) {
+		b.multiRow = true
+		b.maxParams = maxParams
+	}
+}
+
+// WithBatchTxOptions sets the options of the transaction ExecBatch runs in.
+func WithBatchTxOptions(opts *sql.TxOptions) BatchOption {
+	return func(b *batch) {
+		b.txOptions = opts
+	}
+}
+
+// ExecBatch executes the named query once for every argument set in rows
+// inside a single transaction, preparing it only once. It stops at the first
+// failing statement, rolls the transaction back and returns a *BatchError
+// locating the argument sets involved.
+func (s *SquareSql) ExecBatch(ctx context.Context, db TxBeginner, name string, rows [][]interface{}, opts ...BatchOption) (*BatchResult, error) {
+	b := &batch{}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	result := &BatchResult{}
+	if len(rows) == 0 {
+		return result, nil
+	}
+
+	dialect := s.Dialect()
+	err := s.run(ctx, db, name, func(query string) error {
+		*result = BatchResult{}
+		chunk := 1
+		render := func(n int) string { return query }
+		if b.multiRow {
+			values, err := parseValues(query, dialect)
+			if err != nil {
+				return fmt.Errorf("squaresql: batch '%s': %v", name, err)
+			}
+			maxParams := b.maxParams
+			if maxParams <= 0 {
+				maxParams = dialect.maxParams()
+			}
+			if chunk = maxParams / values.params; chunk < 1 {
+				chunk = 1
+			}
+			render = values.render
+			for i, args := range rows {
+				if len(args) != values.params {
+					return &BatchError{Name: name, Row: i, Rows: 1, Err: fmt.Errorf("expected %d arguments, got %d", values.params, len(args))}
+				}
+			}
+		}
+
+		tx, err := db.BeginTx(ctx, b.txOptions)
+		if err != nil {
+			return err
+		}
+		if err := execChunks(ctx, tx, name, rows, chunk, render, result); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateTagsOf(name)
+	return result, nil
+}
+
+// execChunks executes rows in statements covering chunk argument sets each,
+// preparing the statement text returned by render once per chunk size.
+func execChunks(ctx context.Context, tx *sql.Tx, name string, rows [][]interface{}, chunk int, render func(n int) string, result *BatchResult) error {
+	stmts := make(map[int]*sql.Stmt)
+	defer func() {
+		for _, stmt := range stmts {
+			stmt.Close()
+		}
+	}()
+
+	for start := 0; start < len(rows); start += chunk {
+		end := start + chunk
+		if end > len(rows) {
+			end = len(rows)
+		}
+		fail := func(err error) error {
+			return &BatchError{Name: name, Row: start, Rows: end - start, Err: err}
+		}
+
+		stmt, ok := stmts[end-start]
+		if !ok {
+			var err error
+			if stmt, err = tx.PrepareContext(ctx, render(end-start)); err != nil {
+				return fail(err)
+			}
+			stmts[end-start] = stmt
+		}
+
+		var args []interface{}
+		for _, row := range rows[start:end] {
+			args = append(args, row...)
+		}
+		res, err := stmt.ExecContext(ctx, args...)
+		if err != nil {
+			return fail(err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fail(err)
+		}
+		result.RowsAffected += affected
+		result.Statements++
+	}
+
+	return nil
+}
+
+// valuesClause is an INSERT statement split around the tuple of its VALUES
+// clause.
+type valuesClause struct {
+	before, after string
+	tuple         []token
+	// params is the number of arguments of a tuple; numbered is set when
+	// they are referenced by number, as in $1, ?1 or @p1, rather than as ?.
+	params   int
+	numbered bool
+}
+
+func parseValues(query string, dialect Dialect) (*valuesClause, error) {
+	tokens := tokenize(query, dialect)
+
+	open := -1
+	for i := 0; i < len(tokens) && open < 0; i++ {
+		if !tokens[i].is("values") {
+			continue
+		}
+		for j := i + 1; j < len(tokens); j++ {
+			if tokens[j].significant() {
+				if tokens[j].text == "(" {
+					open = j
+				}
+				break
+			}
+		}
+		if open < 0 {
+			return nil, fmt.Errorf("VALUES is not followed by a tuple")
+		}
+	}
+	if open < 0 {
+		return nil, fmt.Errorf("no VALUES clause")
+	}
+
+	depth, end := 0, -1
+	for i := open; i < len(tokens) && end < 0; i++ {
+		switch tokens[i].text {
+		case "(":
+			depth++
+		case ")":
+			if depth--; depth == 0 {
+				end = i
+			}
+		}
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated VALUES tuple")
+	}
+
+	v := &valuesClause{tuple: tokens[open : end+1]}
+	var b strings.Builder
+	for i, t := range tokens {
+		if t.kind == tokenParam && (i < open || i > end) {
+			return nil, fmt.Errorf("parameter %s is outside the VALUES tuple", t.text)
+		}
+		if i == open {
+			v.before, b = b.String(), strings.Builder{}
+		}
+		if i < open || i > end {
+			b.WriteString(t.text)
+		}
+	}
+	v.after = b.String()
+
+	plain := 0
+	for _, t := range v.tuple {
+		if t.kind != tokenParam {
+			continue
+		}
+		if t.text == "?" {
+			plain++
+			continue
+		}
+		n, err := strconv.Atoi(t.text[len(paramPrefix(t.text)):])
+		if err != nil {
+			return nil, fmt.Errorf("named parameter %s cannot be repeated", t.text)
+		}
+		v.numbered = true
+		if n > v.params {
+			v.params = n
+		}
+	}
+	if v.numbered && plain > 0 {
+		return nil, fmt.Errorf("mixed numbered and positional parameters")
+	}
+	if !v.numbered {
+		v.params = plain
+	}
+	if v.params == 0 {
+		return nil, fmt.Errorf("no parameters in the VALUES tuple")
+	}
+
+	return v, nil
+}
+
+// paramPrefix returns the prefix of the numbered parameter param, such as $
+// in $1 and @p in the @p1 of SQL Server.
+func paramPrefix(param string) string {
+	if strings.HasPrefix(param, "@p") {
+		return "@p"
+	}
+	return param[:1]
+}
+
+// render returns the statement inserting n tuples, renumbering numbered
+// parameters so that tuple i takes arguments i*params+1 onwards.
+func (v *valuesClause) render(n int) string {
+	var b strings.Builder
+	b.WriteString(v.before)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		for _, t := range v.tuple {
+			if t.kind == tokenParam && v.numbered {
+				prefix := paramPrefix(t.text)
+				num, _ := strconv.Atoi(t.text[len(prefix):])
+				b.WriteString(prefix + strconv.Itoa(num+i*v.params))
+				continue
+			}
+			b.WriteString(t.text)
+		}
+	}
+	b.WriteString(v.after)
+	return b.String()
+}
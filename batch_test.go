@@ -0,0 +1,127 @@
+package squaresql_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecBatch(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: insert-user
+	INSERT INTO users (name, age) VALUES (?, ?)
+
+	-- name: insert-user-pg
+	INSERT INTO users (name, age) VALUES ($1, lower($2)) ON CONFLICT DO NOTHING
+
+	-- name: insert-named
+	INSERT INTO users (name) VALUES (:name)
+
+	-- name: upsert-user
+	INSERT INTO users (name) VALUES (?) ON DUPLICATE KEY UPDATE seen = ?
+	`)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	rows := [][]interface{}{{"alice", 30}, {"bob", 40}, {"carol", 50}}
+
+	t.Run("prepared", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectBegin()
+		for _, row := range rows {
+			mock.ExpectExec("insert-user").WithArgs(row...).WillReturnResult(0, 1)
+		}
+		mock.ExpectCommit()
+
+		res, err := square.ExecBatch(ctx, db, "insert-user", rows)
+		assert.NoError(t, err)
+		assert.Equal(t, &squaresql.BatchResult{RowsAffected: 3, Statements: 3}, res)
+	})
+
+	t.Run("rolls back", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		failure := errors.New("duplicate key")
+		mock.ExpectBegin()
+		mock.ExpectExec("insert-user").WithArgs("alice", 30).WillReturnResult(0, 1)
+		mock.ExpectExec("insert-user").WithArgs("bob", 40).WillReturnError(failure)
+		mock.ExpectRollback()
+
+		_, err := square.ExecBatch(ctx, db, "insert-user", rows)
+		var batchErr *squaresql.BatchError
+		if assert.True(t, errors.As(err, &batchErr)) {
+			assert.Equal(t, 1, batchErr.Row)
+			assert.Equal(t, 1, batchErr.Rows)
+		}
+		assert.True(t, errors.Is(err, failure))
+	})
+
+	t.Run("multi-row values", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectBegin()
+		mock.ExpectExecSQL("INSERT INTO users (name, age) VALUES (?, ?), (?, ?)").
+			WithArgs("alice", 30, "bob", 40).WillReturnResult(0, 2)
+		mock.ExpectExecSQL("INSERT INTO users (name, age) VALUES (?, ?)").
+			WithArgs("carol", 50).WillReturnResult(0, 1)
+		mock.ExpectCommit()
+
+		res, err := square.ExecBatch(ctx, db, "insert-user", rows, squaresql.WithMultiRowValues(5))
+		assert.NoError(t, err)
+		assert.Equal(t, &squaresql.BatchResult{RowsAffected: 3, Statements: 2}, res)
+	})
+
+	t.Run("multi-row values renumbers parameters", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectBegin()
+		mock.ExpectExecSQL("INSERT INTO users (name, age) VALUES ($1, lower($2)), ($3, lower($4)), ($5, lower($6)) ON CONFLICT DO NOTHING").
+			WithArgs("alice", 30, "bob", 40, "carol", 50).WillReturnResult(0, 3)
+		mock.ExpectCommit()
+
+		res, err := square.ExecBatch(ctx, db, "insert-user-pg", rows, squaresql.WithMultiRowValues(0))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), res.RowsAffected)
+	})
+
+	t.Run("multi-row failure locates rows", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		failure := errors.New("value too long")
+		mock.ExpectBegin()
+		mock.ExpectExecSQL("INSERT INTO users (name, age) VALUES (?, ?), (?, ?)").WillReturnResult(0, 2)
+		mock.ExpectExecSQL("INSERT INTO users (name, age) VALUES (?, ?)").WillReturnError(failure)
+		mock.ExpectRollback()
+
+		_, err := square.ExecBatch(ctx, db, "insert-user", rows, squaresql.WithMultiRowValues(4))
+		var batchErr *squaresql.BatchError
+		if assert.True(t, errors.As(err, &batchErr)) {
+			assert.Equal(t, 2, batchErr.Row)
+			assert.Equal(t, 1, batchErr.Rows)
+		}
+	})
+
+	t.Run("multi-row argument count", func(t *testing.T) {
+		db, _ := squaresqltest.New(t, square)
+		_, err := square.ExecBatch(ctx, db, "insert-user", [][]interface{}{{"alice", 30}, {"bob"}}, squaresql.WithMultiRowValues(0))
+		var batchErr *squaresql.BatchError
+		if assert.True(t, errors.As(err, &batchErr)) {
+			assert.Equal(t, 1, batchErr.Row)
+		}
+	})
+
+	t.Run("multi-row unsupported", func(t *testing.T) {
+		db, _ := squaresqltest.New(t, square)
+		for _, name := range []string{"insert-named", "upsert-user"} {
+			_, err := square.ExecBatch(ctx, db, name, rows, squaresql.WithMultiRowValues(0))
+			assert.Error(t, err, name)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		db, _ := squaresqltest.New(t, square)
+		res, err := square.ExecBatch(ctx, db, "insert-user", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, &squaresql.BatchResult{}, res)
+	})
+}
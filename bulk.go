@@ -0,0 +1,344 @@
+package squaresql
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RowSource yields the rows of a bulk load one at a time. Next returns io.EOF
+// once there are no more rows.
+type RowSource interface {
+	Next() ([]interface{}, error)
+}
+
+// RowFunc adapts an iterator function to a RowSource.
+type RowFunc func() ([]interface{}, error)
+
+func (f RowFunc) Next() ([]interface{}, error) {
+	return f()
+}
+
+// RowsFromChannel returns a RowSource reading rows from ch until it is
+// closed.
+func RowsFromChannel(ch <-chan []interface{}) RowSource {
+	return RowFunc(func() ([]interface{}, error) {
+		row, ok := <-ch
+		if !ok {
+			return nil, io.EOF
+		}
+		return row, nil
+	})
+}
+
+// RowsFromSlice returns a RowSource yielding rows.
+func RowsFromSlice(rows [][]interface{}) RowSource {
+	return RowFunc(func() ([]interface{}, error) {
+		if len(rows) == 0 {
+			return nil, io.EOF
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	})
+}
+
+// BulkLoader is implemented by database handles able to load rows into a
+// table natively, e.g. with PostgreSQL COPY or MySQL LOAD DATA. BulkLoad
+// returns the number of rows loaded. Implementations usually wrap the
+// *sql.DB or *sql.Tx they load through, as CopyLoader and LoadDataLoader
+// do.
+type BulkLoader interface {
+	BulkLoad(ctx context.Context, table string, columns []string, rows RowSource) (int64, error)
+}
+
+// CopyLoader loads rows with `COPY table (columns) FROM STDIN` through a
+// prepared statement of a transaction, as supported by the lib/pq driver.
+type CopyLoader struct {
+	*sql.Tx
+}
+
+// BulkLoad implements BulkLoader.
+func (l *CopyLoader) BulkLoad(ctx context.Context, table string, columns []string, rows RowSource) (int64, error) {
+	stmt, err := l.PrepareContext(ctx, fmt.Sprintf("COPY %s (%s) FROM STDIN", table, strings.Join(columns, ", ")))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var n int64
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	// Executing the statement without arguments flushes the copied rows.
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// LoadDataLoader loads rows with MySQL `LOAD DATA LOCAL INFILE`, streaming
+// them as tab-separated text from a reader registered with the driver under
+// a generated name. With the go-sql-driver/mysql driver:
+//
+//	loader := &squaresql.LoadDataLoader{
+//		ExecerContext:    db,
+//		RegisterReader:   mysql.RegisterReaderHandler,
+//		DeregisterReader: mysql.DeregisterReaderHandler,
+//	}
+type LoadDataLoader struct {
+	ExecerContext
+	// RegisterReader registers handler so that the driver reads the file
+	// 'Reader::name' from the reader it returns.
+	RegisterReader func(name string, handler func() io.Reader)
+	// DeregisterReader removes the handler registered under name.
+	DeregisterReader func(name string)
+}
+
+var loadDataSeq uint64
+
+// BulkLoad implements BulkLoader.
+func (l *LoadDataLoader) BulkLoad(ctx context.Context, table string, columns []string, rows RowSource) (int64, error) {
+	name := fmt.Sprintf("squaresql-%d", atomic.AddUint64(&loadDataSeq, 1))
+	r, w := io.Pipe()
+	l.RegisterReader(name, func() io.Reader { return r })
+	defer l.DeregisterReader(name)
+
+	var sourceErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sourceErr = writeLoadData(w, len(columns), rows)
+		w.CloseWithError(sourceErr)
+	}()
+
+	res, err := l.ExecContext(ctx, fmt.Sprintf(
+		`LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s FIELDS TERMINATED BY '\t' ESCAPED BY '\\' LINES TERMINATED BY '\n' (%s)`,
+		name, table, strings.Join(columns, ", ")))
+	// Unblock the writer when the driver did not read every row.
+	r.CloseWithError(io.ErrClosedPipe)
+	<-done
+
+	if sourceErr != nil {
+		return 0, sourceErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// writeLoadData writes the rows of source to w in the default format of
+// LOAD DATA: tab-separated fields escaped with backslashes, with \N for NULL.
+func writeLoadData(w io.Writer, columns int, source RowSource) error {
+	bw := bufio.NewWriter(w)
+	for n := 1; ; n++ {
+		row, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(row) != columns {
+			return fmt.Errorf("squaresql: bulk row %d has %d values, expected %d", n, len(row), columns)
+		}
+
+		for i, v := range row {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			field, err := loadDataField(v)
+			if err != nil {
+				return fmt.Errorf("squaresql: bulk row %d: %v", n, err)
+			}
+			bw.WriteString(field)
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+var loadDataEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r", "\x00", "\\0")
+
+// loadDataField formats v as a LOAD DATA field.
+func loadDataField(v interface{}) (string, error) {
+	v, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err != nil {
+		return "", err
+	}
+
+	switch x := v.(type) {
+	case nil:
+		return `\N`, nil
+	case bool:
+		if x {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05.999999"), nil
+	case []byte:
+		return loadDataEscaper.Replace(string(x)), nil
+	case string:
+		return loadDataEscaper.Replace(x), nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// BulkOption configures BulkLoad.
+type BulkOption func(*bulk)
+
+type bulk struct {
+	chunk int
+}
+
+// WithBulkChunk sets the number of rows inserted per statement when BulkLoad
+// falls back to multi-row INSERTs. The default fits as many rows as the
+// dialect allows bind parameters.
+func WithBulkChunk(rows int) BulkOption {
+	return func(b *bulk) {
+		b.chunk = rows
+	}
+}
+
+var (
+	bulkTargetRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_.]*)\s*\(([^)]*)\)$`)
+	bulkColumnRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// parseBulkTarget parses a bulk annotation such as `products(id, name)`.
+func parseBulkTarget(annotation string) (table string, columns []string, err error) {
+	matches := bulkTargetRe.FindStringSubmatch(strings.TrimSpace(annotation))
+	if matches == nil {
+		return "", nil, fmt.Errorf("expected table(column, ...), got %q", annotation)
+	}
+
+	for _, column := range strings.Split(matches[2], ",") {
+		column = strings.TrimSpace(column)
+		if !bulkColumnRe.MatchString(column) {
+			return "", nil, fmt.Errorf("invalid column %q", column)
+		}
+		columns = append(columns, column)
+	}
+	return matches[1], columns, nil
+}
+
+// BulkLoad loads the rows of source into the table declared by the named
+// query's bulk annotation, such as `-- bulk: products(id, name, price)`,
+// returning the number of rows loaded. Rows are handed to db's BulkLoad
+// method when db implements BulkLoader, and inserted with chunked multi-row
+// INSERT statements otherwise. Pass a *sql.Tx to load all rows or none.
+func (s *SquareSql) BulkLoad(ctx context.Context, db ExecerContext, name string, source RowSource, opts ...BulkOption) (int64, error) {
+	q, err := s.lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	table, columns, err := parseBulkTarget(q.Annotation("bulk"))
+	if err != nil {
+		return 0, fmt.Errorf("squaresql: bulk annotation of '%s': %v", q.Name, err)
+	}
+
+	b := &bulk{}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	start := time.Now()
+	var n int64
+	if loader, ok := db.(BulkLoader); ok {
+		n, err = loader.BulkLoad(ctx, table, columns, source)
+	} else {
+		n, err = s.insertChunks(ctx, db, table, columns, source, b.chunk)
+	}
+	s.record(q.Name, start, 0, err)
+	if err != nil {
+		return n, err
+	}
+
+	s.invalidateTagsOf(name)
+	return n, nil
+}
+
+// insertChunks inserts the rows of source with multi-row INSERT statements
+// of chunk rows each.
+func (s *SquareSql) insertChunks(ctx context.Context, db ExecerContext, table string, columns []string, source RowSource, chunk int) (int64, error) {
+	dialect := s.Dialect()
+	params := make([]string, len(columns))
+	for i := range params {
+		switch dialect {
+		case Postgres:
+			params[i] = fmt.Sprintf("$%d", i+1)
+		case SQLServer:
+			params[i] = fmt.Sprintf("@p%d", i+1)
+		default:
+			params[i] = "?"
+		}
+	}
+	values, err := parseValues(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(params, ", ")), dialect)
+	if err != nil {
+		return 0, err
+	}
+	if chunk <= 0 {
+		chunk = dialect.maxParams() / len(columns)
+	}
+
+	var (
+		loaded int64
+		args   []interface{}
+		rows   int
+	)
+	flush := func() error {
+		if rows == 0 {
+			return nil
+		}
+		if _, err := db.ExecContext(ctx, values.render(rows), args...); err != nil {
+			return fmt.Errorf("squaresql: bulk loading rows %d-%d into %s: %w", loaded+1, loaded+int64(rows), table, err)
+		}
+		loaded += int64(rows)
+		args, rows = args[:0], 0
+		return nil
+	}
+
+	for {
+		row, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return loaded, err
+		}
+		if len(row) != len(columns) {
+			return loaded, fmt.Errorf("squaresql: bulk row %d has %d values, expected %d", loaded+int64(rows)+1, len(row), len(columns))
+		}
+
+		args = append(args, row...)
+		if rows++; rows == chunk {
+			if err := flush(); err != nil {
+				return loaded, err
+			}
+		}
+	}
+
+	return loaded, flush()
+}
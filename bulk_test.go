@@ -0,0 +1,204 @@
+package squaresql_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLoader records the rows handed to it instead of loading them.
+type fakeLoader struct {
+	*sql.DB
+	table   string
+	columns []string
+	rows    [][]interface{}
+}
+
+func (l *fakeLoader) BulkLoad(ctx context.Context, table string, columns []string, rows squaresql.RowSource) (int64, error) {
+	l.table, l.columns = table, columns
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			return int64(len(l.rows)), nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		l.rows = append(l.rows, row)
+	}
+}
+
+// loadDataDB reads the rows of LOAD DATA statements from the readers
+// registered with it, as the MySQL driver does.
+type loadDataDB struct {
+	readers map[string]func() io.Reader
+	query   string
+	data    string
+}
+
+func (d *loadDataDB) register(name string, handler func() io.Reader) {
+	d.readers[name] = handler
+}
+
+func (d *loadDataDB) deregister(name string) {
+	delete(d.readers, name)
+}
+
+func (d *loadDataDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	d.query = query
+	name := regexp.MustCompile(`'Reader::([^']*)'`).FindStringSubmatch(query)[1]
+	data, err := ioutil.ReadAll(d.readers[name]())
+	if err != nil {
+		return nil, err
+	}
+	d.data = string(data)
+	return driver.RowsAffected(strings.Count(d.data, "\n")), nil
+}
+
+func TestBulkLoad(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: load-products
+	-- bulk: products(id, name, price)
+
+	-- name: broken
+	-- bulk: products
+	`)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	products := [][]interface{}{{1, "tea", 2.5}, {2, "coffee", 3.0}, {3, "cocoa", 3.5}}
+
+	t.Run("multi-row inserts", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectExecSQL("INSERT INTO products (id, name, price) VALUES (?, ?, ?), (?, ?, ?)").
+			WithArgs(1, "tea", 2.5, 2, "coffee", 3.0).WillReturnResult(0, 2)
+		mock.ExpectExecSQL("INSERT INTO products (id, name, price) VALUES (?, ?, ?)").
+			WithArgs(3, "cocoa", 3.5).WillReturnResult(0, 1)
+
+		ch := make(chan []interface{})
+		go func() {
+			for _, p := range products {
+				ch <- p
+			}
+			close(ch)
+		}()
+
+		n, err := square.BulkLoad(ctx, db, "load-products", squaresql.RowsFromChannel(ch), squaresql.WithBulkChunk(2))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+	})
+
+	t.Run("postgres placeholders", func(t *testing.T) {
+		square.SetDialect(squaresql.Postgres)
+		defer square.SetDialect(squaresql.Generic)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectExecSQL("INSERT INTO products (id, name, price) VALUES ($1, $2, $3), ($4, $5, $6), ($7, $8, $9)").WillReturnResult(0, 3)
+
+		n, err := square.BulkLoad(ctx, db, "load-products", squaresql.RowsFromSlice(products))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+	})
+
+	t.Run("sqlserver placeholders", func(t *testing.T) {
+		square.SetDialect(squaresql.SQLServer)
+		defer square.SetDialect(squaresql.Generic)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectExecSQL("INSERT INTO products (id, name, price) VALUES (@p1, @p2, @p3), (@p4, @p5, @p6)").WillReturnResult(0, 2)
+		mock.ExpectExecSQL("INSERT INTO products (id, name, price) VALUES (@p1, @p2, @p3)").WillReturnResult(0, 1)
+
+		n, err := square.BulkLoad(ctx, db, "load-products", squaresql.RowsFromSlice(products), squaresql.WithBulkChunk(2))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+	})
+
+	t.Run("bulk loader", func(t *testing.T) {
+		db, _ := squaresqltest.New(t, square)
+		loader := &fakeLoader{DB: db}
+
+		n, err := square.BulkLoad(ctx, loader, "load-products", squaresql.RowsFromSlice(products))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+		assert.Equal(t, "products", loader.table)
+		assert.Equal(t, []string{"id", "name", "price"}, loader.columns)
+		assert.Equal(t, products, loader.rows)
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		copyIn := "COPY products (id, name, price) FROM STDIN"
+		mock.ExpectBegin()
+		for _, p := range products {
+			mock.ExpectExecSQL(copyIn).WithArgs(p...).WillReturnResult(0, 0)
+		}
+		mock.ExpectExecSQL(copyIn).WithArgs().WillReturnResult(0, 3)
+		mock.ExpectCommit()
+
+		tx, err := db.BeginTx(ctx, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		n, err := square.BulkLoad(ctx, &squaresql.CopyLoader{Tx: tx}, "load-products", squaresql.RowsFromSlice(products))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+		assert.NoError(t, tx.Commit())
+	})
+
+	t.Run("load data", func(t *testing.T) {
+		db := &loadDataDB{readers: make(map[string]func() io.Reader)}
+		loader := &squaresql.LoadDataLoader{ExecerContext: db, RegisterReader: db.register, DeregisterReader: db.deregister}
+		created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+		rows := [][]interface{}{
+			{1, "tea\tgreen", created},
+			{2, nil, true},
+			{3, `back\slash`, []byte("line\nbreak")},
+		}
+
+		n, err := square.BulkLoad(ctx, loader, "load-products", squaresql.RowsFromSlice(rows))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+		assert.Regexp(t, `^LOAD DATA LOCAL INFILE 'Reader::squaresql-\d+' INTO TABLE products .* \(id, name, price\)$`, db.query)
+		assert.Equal(t, "1\ttea\\tgreen\t2021-03-04 05:06:07\n"+
+			"2\t\\N\t1\n"+
+			"3\tback\\\\slash\tline\\nbreak\n", db.data)
+		assert.Empty(t, db.readers, "the reader is deregistered")
+
+		failure := errors.New("source failed")
+		source := squaresql.RowFunc(func() ([]interface{}, error) { return nil, failure })
+		_, err = square.BulkLoad(ctx, loader, "load-products", source)
+		assert.True(t, errors.Is(err, failure))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		failure := errors.New("duplicate key")
+		mock.ExpectExecSQL("INSERT INTO products (id, name, price) VALUES (?, ?, ?)").WillReturnResult(0, 1)
+		mock.ExpectExecSQL("INSERT INTO products (id, name, price) VALUES (?, ?, ?)").WillReturnError(failure)
+
+		n, err := square.BulkLoad(ctx, db, "load-products", squaresql.RowsFromSlice(products), squaresql.WithBulkChunk(1))
+		assert.Error(t, err)
+		assert.Equal(t, int64(1), n)
+
+		_, err = square.BulkLoad(ctx, db, "load-products", squaresql.RowsFromSlice([][]interface{}{{1, "tea"}}))
+		assert.Error(t, err)
+
+		_, err = square.BulkLoad(ctx, db, "broken", squaresql.RowsFromSlice(products))
+		assert.Error(t, err)
+
+		source := squaresql.RowFunc(func() ([]interface{}, error) { return nil, failure })
+		_, err = square.BulkLoad(ctx, db, "load-products", source)
+		assert.True(t, errors.Is(err, failure))
+	})
+}
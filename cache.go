@@ -0,0 +1,241 @@
+package squaresql
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is the number of entries kept by NewLRUCache when no
+// positive size is given.
+const DefaultCacheSize = 1024
+
+// CacheStore stores the result sets cached by Fetch. Implementations must be
+// safe for concurrent use.
+type CacheStore interface {
+	// Get returns the result set stored under key, unless it has expired.
+	Get(key string) (*ResultSet, bool)
+	// Set stores rs under key for ttl.
+	Set(key string, rs *ResultSet, ttl time.Duration)
+}
+
+// LRUCache is an in-memory CacheStore holding a bounded number of entries,
+// evicting the least recently used one when full.
+type LRUCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	rs      *ResultSet
+	expires time.Time
+}
+
+// NewLRUCache returns an LRUCache holding up to size entries.
+func NewLRUCache(size int) *LRUCache {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	return &LRUCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+func (c *LRUCache) Get(key string) (*ResultSet, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if !c.now().Before(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return entry.rs, true
+}
+
+func (c *LRUCache) Set(key string, rs *ResultSet, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		el.Value = &lruEntry{key: key, rs: rs, expires: expires}
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, rs: rs, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Len returns the number of entries held, including expired ones not yet
+// evicted.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// SetCache enables caching of the results of queries annotated with
+// `-- cache: <duration>` in store. A nil store disables caching.
+func (s *SquareSql) SetCache(store CacheStore) {
+	root, _ := s.scope()
+	root.cacheMu.Lock()
+	root.cache = store
+	root.cacheMu.Unlock()
+}
+
+// cacheFor returns the store and time to live for results of q, or a nil
+// store when they are not cached.
+func (s *SquareSql) cacheFor(q *Query) (CacheStore, time.Duration, error) {
+	annotation := q.Annotation("cache")
+	if len(annotation) == 0 {
+		return nil, 0, nil
+	}
+
+	ttl, err := time.ParseDuration(annotation)
+	if err != nil || ttl <= 0 {
+		return nil, 0, fmt.Errorf("squaresql: invalid cache annotation %q on '%s'", annotation, q.Name)
+	}
+
+	root, _ := s.scope()
+	root.cacheMu.Lock()
+	defer root.cacheMu.Unlock()
+
+	return root.cache, ttl, nil
+}
+
+// cacheKey identifies the result of q for args and the identifiers of ctx.
+// It embeds the generations of the query name, which changing the query
+// bumps too, and of its tags, so that invalidating either makes earlier
+// entries unreachable; they are left for the store to expire or evict.
+func (s *SquareSql) cacheKey(ctx context.Context, q *Query, args []interface{}) string {
+	root, _ := s.scope()
+	root.cacheMu.Lock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\x00%s\x00%d", q.Name, q.Dialect, root.generations["name:"+q.Name])
+	for _, tag := range q.Tags() {
+		fmt.Fprintf(&b, "\x00%s=%d", tag, root.generations["tag:"+tag])
+	}
+	root.cacheMu.Unlock()
+
+	for _, name := range q.placeholders {
+		value, _ := s.identifier(ctx, name)
+		fmt.Fprintf(&b, "\x00{{%s}}=%s", name, value)
+	}
+	for _, arg := range args {
+		b.WriteByte(0)
+		writeCacheArg(&b, arg)
+	}
+	return b.String()
+}
+
+// writeCacheArg writes arg to a cache key as the value the driver is sent,
+// so that pointers and driver.Valuers are keyed by what they refer to. The
+// value is length-prefixed so that no two argument lists share a key.
+func writeCacheArg(b *strings.Builder, arg interface{}) {
+	if named, ok := arg.(sql.NamedArg); ok {
+		fmt.Fprintf(b, "@%d:%s=", len(named.Name), named.Name)
+		arg = named.Value
+	}
+
+	v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+	if err != nil {
+		s := fmt.Sprintf("%#v", arg)
+		fmt.Fprintf(b, "%T:%d:%s", arg, len(s), s)
+		return
+	}
+
+	var s string
+	switch x := v.(type) {
+	case nil:
+		s = ""
+	case []byte:
+		s = string(x)
+	case string:
+		s = x
+	case time.Time:
+		s = x.Format(time.RFC3339Nano)
+	default:
+		s = fmt.Sprint(x)
+	}
+	fmt.Fprintf(b, "%T:%d:%s", v, len(s), s)
+}
+
+func (s *SquareSql) bump(keys ...string) {
+	root, _ := s.scope()
+	root.cacheMu.Lock()
+	defer root.cacheMu.Unlock()
+
+	if root.generations == nil {
+		root.generations = make(map[string]uint64)
+	}
+	for _, key := range keys {
+		root.generations[key]++
+	}
+}
+
+// InvalidateCache discards the cached results of the named query.
+func (s *SquareSql) InvalidateCache(name string) {
+	_, prefix := s.scope()
+	s.bump("name:" + prefix + name)
+}
+
+// InvalidateCacheTag discards the cached results of every query tagged with
+// tag.
+func (s *SquareSql) InvalidateCacheTag(tag string) {
+	s.bump("tag:" + tag)
+}
+
+// invalidateNames discards the cached results of the queries named names,
+// qualified by their namespace, after their SQL or dialect changed.
+func (s *SquareSql) invalidateNames(names ...string) {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = "name:" + name
+	}
+	s.bump(keys...)
+}
+
+// invalidateTagsOf discards the cached results sharing a tag with the named
+// query after it modified the database.
+func (s *SquareSql) invalidateTagsOf(name string) {
+	q, err := s.lookup(name)
+	if err != nil {
+		return
+	}
+
+	tags := q.Tags()
+	if len(tags) == 0 {
+		return
+	}
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = "tag:" + tag
+	}
+	s.bump(keys...)
+}
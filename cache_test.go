@@ -0,0 +1,184 @@
+package squaresql_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchCache(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: get-user
+	-- cache: 1m
+	-- tags: users
+	SELECT id, name FROM users WHERE id = ?
+
+	-- name: count-orders
+	SELECT count(*) FROM orders
+
+	-- name: rename-user
+	-- tags: users
+	UPDATE users SET name = ? WHERE id = ?
+
+	-- name: broken
+	-- cache: soon
+	SELECT 1
+	`)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	rows := func() *squaresqltest.Rows {
+		return squaresqltest.NewRows("id", "name").AddRow(int64(1), "alice")
+	}
+	want := &squaresql.ResultSet{
+		Columns: []string{"id", "name"},
+		Rows:    [][]interface{}{{int64(1), "alice"}},
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(rows())
+		mock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(rows())
+
+		for i := 0; i < 2; i++ {
+			rs, err := square.Fetch(ctx, db, "get-user", 1)
+			assert.NoError(t, err)
+			assert.Equal(t, want, rs)
+		}
+	})
+
+	square.SetCache(squaresql.NewLRUCache(0))
+	defer square.SetCache(nil)
+
+	t.Run("hits", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(rows())
+		mock.ExpectQuery("get-user").WithArgs(2).WillReturnRows(squaresqltest.NewRows("id", "name"))
+
+		for i := 0; i < 3; i++ {
+			rs, err := square.Fetch(ctx, db, "get-user", 1)
+			assert.NoError(t, err)
+			assert.Equal(t, want, rs)
+		}
+		rs, err := square.Fetch(ctx, db, "get-user", 2)
+		assert.NoError(t, err)
+		assert.Empty(t, rs.Rows)
+	})
+
+	t.Run("pointer args are keyed by value", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("get-user").WithArgs(3).WillReturnRows(squaresqltest.NewRows("id", "name").AddRow(int64(3), "carol"))
+		mock.ExpectQuery("get-user").WithArgs(4).WillReturnRows(squaresqltest.NewRows("id", "name").AddRow(int64(4), "dave"))
+
+		id := 3
+		rs, err := square.Fetch(ctx, db, "get-user", &id)
+		assert.NoError(t, err)
+		assert.Equal(t, "carol", rs.Rows[0][1])
+
+		id = 4
+		rs, err = square.Fetch(ctx, db, "get-user", &id)
+		assert.NoError(t, err)
+		assert.Equal(t, "dave", rs.Rows[0][1])
+
+		rs, err = square.Fetch(ctx, db, "get-user", int64(3))
+		assert.NoError(t, err)
+		assert.Equal(t, "carol", rs.Rows[0][1], "served from the cache")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("not annotated", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("count-orders").WillReturnRows(squaresqltest.NewRows("count").AddRow(int64(3)))
+		mock.ExpectQuery("count-orders").WillReturnRows(squaresqltest.NewRows("count").AddRow(int64(4)))
+
+		for _, n := range []int64{3, 4} {
+			rs, err := square.Fetch(ctx, db, "count-orders")
+			assert.NoError(t, err)
+			assert.Equal(t, [][]interface{}{{n}}, rs.Rows)
+		}
+	})
+
+	t.Run("invalidates by name and tag", func(t *testing.T) {
+		square.InvalidateCache("get-user")
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(rows())
+		mock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(rows())
+		mock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(rows())
+
+		_, err := square.Fetch(ctx, db, "get-user", 1)
+		assert.NoError(t, err)
+		square.InvalidateCache("get-user")
+		_, err = square.Fetch(ctx, db, "get-user", 1)
+		assert.NoError(t, err)
+		square.InvalidateCacheTag("users")
+		_, err = square.Fetch(ctx, db, "get-user", 1)
+		assert.NoError(t, err)
+		_, err = square.Fetch(ctx, db, "get-user", 1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalidates on exec", func(t *testing.T) {
+		square.InvalidateCache("get-user")
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(rows())
+		mock.ExpectExec("rename-user").WithArgs("bob", 1).WillReturnResult(0, 1)
+		mock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(rows())
+
+		_, err := square.Fetch(ctx, db, "get-user", 1)
+		assert.NoError(t, err)
+		_, err = square.ExecContext(ctx, db, "rename-user", "bob", 1)
+		assert.NoError(t, err)
+		_, err = square.Fetch(ctx, db, "get-user", 1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalid annotation", func(t *testing.T) {
+		db, _ := squaresqltest.New(t, square)
+		_, err := square.Fetch(ctx, db, "broken")
+		assert.Error(t, err)
+	})
+
+	t.Run("counts hits", func(t *testing.T) {
+		square.ResetStats()
+		square.InvalidateCache("get-user")
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(rows())
+
+		for i := 0; i < 3; i++ {
+			_, err := square.Fetch(ctx, db, "get-user", 1)
+			assert.NoError(t, err)
+		}
+		stats := square.Stats()["get-user"]
+		assert.Equal(t, int64(1), stats.Calls)
+		assert.Equal(t, int64(2), stats.CacheHits)
+	})
+
+	t.Run("invalidates when the query changes", func(t *testing.T) {
+		square.InvalidateCache("get-user")
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuerySQL("SELECT id, name FROM users WHERE id = ?").WithArgs(1).WillReturnRows(rows())
+		mock.ExpectQuerySQL("SELECT id, name FROM people WHERE id = ?").WithArgs(1).WillReturnRows(rows())
+		mock.ExpectQuerySQL("SELECT id, name FROM users WHERE id = ?").WithArgs(1).WillReturnRows(rows())
+
+		_, err := square.Fetch(ctx, db, "get-user", 1)
+		assert.NoError(t, err)
+		square.AddQuery(&squaresql.Query{
+			Name:        "get-user",
+			SQL:         "SELECT id, name FROM people WHERE id = ?",
+			Annotations: map[string]string{"cache": "1m"},
+		})
+		_, err = square.Fetch(ctx, db, "get-user", 1)
+		assert.NoError(t, err)
+		square.AddQuery(&squaresql.Query{
+			Name:        "get-user",
+			SQL:         "SELECT id, name FROM users WHERE id = ?",
+			Annotations: map[string]string{"cache": "1m"},
+		})
+		_, err = square.Fetch(ctx, db, "get-user", 1)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
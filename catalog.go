@@ -0,0 +1,252 @@
+package squaresql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// catalog is the serialised form of a SquareSql.
+type catalog struct {
+	Dialect Dialect  `json:"dialect,omitempty"`
+	Queries []*Query `json:"queries"`
+}
+
+// Queries returns a copy of every query, including all dialect variants,
+// sorted by name and dialect.
+func (s *SquareSql) Queries() []*Query {
+	sets, _ := s.snapshot()
+
+	var queries []*Query
+	for _, set := range sets {
+		for _, q := range set {
+			queries = append(queries, q.clone())
+		}
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		if queries[i].Name != queries[j].Name {
+			return queries[i].Name < queries[j].Name
+		}
+		return queries[i].Dialect < queries[j].Dialect
+	})
+
+	return queries
+}
+
+func (s *SquareSql) catalog() *catalog {
+	return &catalog{Dialect: s.Dialect(), Queries: s.Queries()}
+}
+
+func (c *catalog) squareSql() *SquareSql {
+	for _, q := range c.Queries {
+		q.derive(c.Dialect)
+	}
+	return &SquareSql{queries: collect(c.Queries), dialect: c.Dialect}
+}
+
+// ExportJSON writes the whole catalog, i.e. every query with its
+// annotations, source location and fingerprint, to w as JSON.
+func (s *SquareSql) ExportJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s.catalog())
+}
+
+// LoadJSON loads a catalog written by ExportJSON.
+func LoadJSON(r io.Reader) (*SquareSql, error) {
+	c := &catalog{}
+	if err := json.NewDecoder(r).Decode(c); err != nil {
+		return nil, fmt.Errorf("squaresql: decoding catalog: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return c.squareSql(), nil
+}
+
+// ExportYAML writes the catalog to w as YAML.
+func (s *SquareSql) ExportYAML(w io.Writer) error {
+	c := s.catalog()
+	var b strings.Builder
+
+	if len(c.Dialect) > 0 {
+		fmt.Fprintf(&b, "dialect: %s\n", yamlString(string(c.Dialect)))
+	}
+	if len(c.Queries) == 0 {
+		b.WriteString("queries: []\n")
+	} else {
+		b.WriteString("queries:\n")
+	}
+	for _, q := range c.Queries {
+		fmt.Fprintf(&b, "  - name: %s\n", yamlString(q.Name))
+		if len(q.Dialect) > 0 {
+			fmt.Fprintf(&b, "    dialect: %s\n", yamlString(string(q.Dialect)))
+		}
+		fmt.Fprintf(&b, "    sql: %s\n", yamlBlock(q.SQL, "      "))
+		if len(q.Annotations) > 0 {
+			b.WriteString("    annotations:\n")
+			keys := make([]string, 0, len(q.Annotations))
+			for k := range q.Annotations {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(&b, "      %s: %s\n", k, yamlString(q.Annotations[k]))
+			}
+		}
+		if len(q.File) > 0 {
+			fmt.Fprintf(&b, "    file: %s\n", yamlString(q.File))
+		}
+		if q.Line > 0 {
+			fmt.Fprintf(&b, "    line: %d\n", q.Line)
+		}
+		if len(q.Normalized) > 0 {
+			fmt.Fprintf(&b, "    normalized: %s\n", yamlString(q.Normalized))
+		}
+		if len(q.Fingerprint) > 0 {
+			fmt.Fprintf(&b, "    fingerprint: %s\n", yamlString(q.Fingerprint))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// LoadYAML loads a catalog written by ExportYAML. Only the subset of YAML
+// needed to describe a catalog is understood: block mappings and sequences,
+// plain and quoted scalars, literal block scalars and comments.
+func LoadYAML(r io.Reader) (*SquareSql, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := parseYAML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("squaresql: decoding catalog: %v", err)
+	}
+
+	c, err := catalogFromYAML(tree)
+	if err != nil {
+		return nil, fmt.Errorf("squaresql: decoding catalog: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return c.squareSql(), nil
+}
+
+func (c *catalog) validate() error {
+	for i, q := range c.Queries {
+		if q == nil || len(q.Name) == 0 {
+			return fmt.Errorf("squaresql: query %d of the catalog has no name", i+1)
+		}
+	}
+	return nil
+}
+
+func catalogFromYAML(tree interface{}) (*catalog, error) {
+	root, ok := tree.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("catalog must be a mapping")
+	}
+
+	c := &catalog{}
+	for key, value := range root {
+		switch key {
+		case "dialect":
+			s, err := yamlScalar(key, value)
+			if err != nil {
+				return nil, err
+			}
+			c.Dialect = Dialect(s)
+		case "queries":
+			items, ok := value.([]interface{})
+			if !ok && value != nil {
+				return nil, fmt.Errorf("queries must be a sequence")
+			}
+			for _, item := range items {
+				q, err := queryFromYAML(item)
+				if err != nil {
+					return nil, err
+				}
+				c.Queries = append(c.Queries, q)
+			}
+		default:
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+	}
+
+	return c, nil
+}
+
+func queryFromYAML(item interface{}) (*Query, error) {
+	fields, ok := item.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("query must be a mapping")
+	}
+
+	q := &Query{}
+	for key, value := range fields {
+		if key == "annotations" {
+			annotations, ok := value.(map[string]interface{})
+			if !ok && value != nil {
+				return nil, fmt.Errorf("annotations must be a mapping")
+			}
+			for k, v := range annotations {
+				s, err := yamlScalar(k, v)
+				if err != nil {
+					return nil, err
+				}
+				if q.Annotations == nil {
+					q.Annotations = make(map[string]string)
+				}
+				q.Annotations[strings.ToLower(k)] = s
+			}
+			continue
+		}
+
+		s, err := yamlScalar(key, value)
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "name":
+			q.Name = s
+		case "sql":
+			q.SQL = s
+		case "dialect":
+			q.Dialect = Dialect(s)
+		case "file":
+			q.File = s
+		case "line":
+			if q.Line, err = strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("line of query %q: %v", q.Name, err)
+			}
+		case "normalized":
+			q.Normalized = s
+		case "fingerprint":
+			q.Fingerprint = s
+		default:
+			return nil, fmt.Errorf("unknown query key %q", key)
+		}
+	}
+
+	return q, nil
+}
+
+func yamlScalar(key string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("%s must be a scalar", key)
+}
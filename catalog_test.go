@@ -0,0 +1,149 @@
+package squaresql
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadCatalogFixture(t *testing.T) *SquareSql {
+	path := filepath.Join(t.TempDir(), "users.sql")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`-- name: find-user
+-- cache: 30s
+-- tags: users, "core"
+SELECT id, name
+FROM users
+WHERE id = ?
+
+-- name: upsert-user
+-- dialect: postgres
+INSERT INTO users (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name
+
+-- name: upsert-user
+INSERT INTO users (id, name) VALUES (?, ?)
+
+-- name: indented
+    SELECT 'a: b'
+  FROM dual
+`), 0600))
+
+	square, err := LoadFromFile(path, WithScanMode(ScanPreserve), WithDialect(Postgres))
+	assert.NoError(t, err)
+	return square
+}
+
+func TestQueriesReturnsAllVariants(t *testing.T) {
+	square := loadCatalogFixture(t)
+
+	queries := square.Queries()
+	if assert.Len(t, queries, 4) {
+		assert.Equal(t, "find-user", queries[0].Name)
+		assert.Equal(t, 1, queries[0].Line)
+		assert.Equal(t, "users.sql", filepath.Base(queries[0].File))
+		assert.Equal(t, "30s", queries[0].Annotation("cache"))
+		assert.NotEmpty(t, queries[0].Fingerprint)
+		assert.Equal(t, "indented", queries[1].Name)
+		assert.Equal(t, Generic, queries[2].Dialect)
+		assert.Equal(t, Postgres, queries[3].Dialect)
+	}
+
+	queries[0].Annotations["cache"] = "changed"
+	query, err := square.Lookup("find-user")
+	assert.NoError(t, err)
+	assert.Equal(t, "30s", query.Annotation("cache"))
+}
+
+func TestCatalogJSONRoundTrip(t *testing.T) {
+	square := loadCatalogFixture(t)
+
+	var buf bytes.Buffer
+	assert.NoError(t, square.ExportJSON(&buf))
+	assert.Contains(t, buf.String(), `"dialect": "postgres"`)
+	assert.Contains(t, buf.String(), `"cache": "30s"`)
+
+	loaded, err := LoadJSON(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, square.Queries(), loaded.Queries())
+	assert.Equal(t, Postgres, loaded.Dialect())
+
+	_, err = LoadJSON(strings.NewReader(`{"queries": [{"sql": "SELECT 1"}]}`))
+	assert.Error(t, err)
+}
+
+func TestCatalogYAMLRoundTrip(t *testing.T) {
+	square := loadCatalogFixture(t)
+
+	var buf bytes.Buffer
+	assert.NoError(t, square.ExportYAML(&buf))
+	assert.Contains(t, buf.String(), "    sql: |-\n      SELECT id, name\n      FROM users\n")
+	assert.Contains(t, buf.String(), `    sql: "    SELECT 'a: b'\n  FROM dual"`)
+
+	loaded, err := LoadYAML(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, square.Queries(), loaded.Queries())
+	assert.Equal(t, Postgres, loaded.Dialect())
+
+	var empty bytes.Buffer
+	assert.NoError(t, (&SquareSql{}).ExportYAML(&empty))
+	loaded, err = LoadYAML(&empty)
+	assert.NoError(t, err)
+	assert.Empty(t, loaded.Queries())
+}
+
+func TestCatalogYAMLRoundTripPreservesWhitespace(t *testing.T) {
+	square, err := LoadFromString("-- name: tabs\nSELECT id\n\tFROM users\n\n-- name: blank\nSELECT 'a'\n   \nFROM dual\n", WithScanMode(ScanPreserve))
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, square.ExportYAML(&buf))
+
+	loaded, err := LoadYAML(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, square.Queries(), loaded.Queries())
+
+	raw, err := loaded.Raw("blank")
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT 'a'\n   \nFROM dual", raw)
+}
+
+func TestLoadYAMLHandwritten(t *testing.T) {
+	doc := `---
+# Queries reviewed by the DBA team.
+queries:
+- name: list-products   # trailing comment
+  sql: |
+    SELECT *
+      FROM products
+
+    ORDER BY name
+  annotations:
+    readonly: true
+    note: 'it''s fine'
+- name: count-products
+  sql: SELECT count(*) FROM products
+  line: 7
+`
+
+	square, err := LoadYAML(strings.NewReader(doc))
+	assert.NoError(t, err)
+
+	list, err := square.Lookup("list-products")
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT *\n  FROM products\n\nORDER BY name\n", list.SQL)
+	assert.Equal(t, map[string]string{"readonly": "true", "note": "it's fine"}, list.Annotations)
+
+	count, err := square.Lookup("count-products")
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT count(*) FROM products", count.SQL)
+	assert.Equal(t, 7, count.Line)
+
+	_, err = LoadYAML(strings.NewReader("queries:\n  - name: x\n    sql: >\n      folded\n"))
+	assert.Error(t, err)
+
+	_, err = LoadYAML(strings.NewReader("queries:\n  - name: x\n    colour: red\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,10 @@
+package main
+
+// This binary links no database driver, so that the module does not depend
+// on any. Its explain command only works once a driver is linked in with a
+// blank import in this file, such as
+//
+//	import _ "github.com/lib/pq"
+//
+// after adding the driver module with go get, or from a program of your own
+// calling squaresqlcmd.Main.
@@ -0,0 +1,17 @@
+// Command squaresql inspects the named queries of SQL files. It is
+// implemented by package squaresqlcmd, whose documentation describes its
+// commands.
+//
+// The explain command needs a database/sql driver, and this binary links
+// none; see drivers.go.
+package main
+
+import (
+	"os"
+
+	"github.com/allapospelova/squaresql/squaresqlcmd"
+)
+
+func main() {
+	os.Exit(squaresqlcmd.Main(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -0,0 +1,147 @@
+package squaresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Stop can be returned, possibly wrapped, by the function passed to Each to
+// end the iteration early. Each then returns nil.
+var Stop = errors.New("squaresql: stop iteration")
+
+// Cursor iterates over the rows of a query, decoding them one at a time.
+//
+//	cur, err := square.Cursor(ctx, db, "list-users")
+//	if err != nil { ... }
+//	defer cur.Close()
+//	for cur.Next() {
+//		var u User
+//		if err := cur.Value(&u); err != nil { ... }
+//	}
+//	if err := cur.Err(); err != nil { ... }
+//
+// Rows are decoded into structs by matching columns with fields by their db
+// tag, snake_case name or case-insensitive name; any other type receives the
+// only column of the row.
+type Cursor struct {
+	ctx      context.Context
+	rows     *sql.Rows
+	columns  []string
+	decoders map[reflect.Type]*decoder
+	err      error
+}
+
+// Cursor runs the named query and returns a Cursor over its rows, which must
+// be closed.
+func (s *SquareSql) Cursor(ctx context.Context, db QueryerContext, name string, args ...interface{}) (*Cursor, error) {
+	rows, err := s.QueryContext(ctx, db, name, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	return &Cursor{ctx: ctx, rows: rows, columns: columns, decoders: make(map[reflect.Type]*decoder)}, nil
+}
+
+// Next advances to the next row, returning false when there are no more rows
+// or an error occurred. The cursor is closed once Next returns false.
+func (c *Cursor) Next() bool {
+	if c.err != nil {
+		return false
+	}
+	if err := c.ctx.Err(); err != nil {
+		c.err = err
+		c.rows.Close()
+		return false
+	}
+	return c.rows.Next()
+}
+
+// Value decodes the current row into dest, which must be a non-nil pointer.
+func (c *Cursor) Value(dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("squaresql: cannot decode into %T, a non-nil pointer is required", dest)
+	}
+	return c.decode(v.Elem())
+}
+
+func (c *Cursor) decode(v reflect.Value) error {
+	d, ok := c.decoders[v.Type()]
+	if !ok {
+		var err error
+		if d, err = newDecoder(v.Type(), c.columns); err != nil {
+			return err
+		}
+		c.decoders[v.Type()] = d
+	}
+	return d.decode(c.rows, v)
+}
+
+// Err returns the error that ended the iteration, if any, including the
+// cancellation of the context.
+func (c *Cursor) Err() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.rows.Err()
+}
+
+// Close closes the rows of the cursor. It is safe to call it more than once.
+func (c *Cursor) Close() error {
+	return c.rows.Close()
+}
+
+// Each runs the named query and calls fn with every row. fn must be a
+// func(T) error, where rows are decoded into T as they are by Cursor.Value.
+// Iteration stops at the first error returned by fn, which Each returns
+// unless it is or wraps Stop. Rows are always closed.
+func (s *SquareSql) Each(ctx context.Context, db QueryerContext, name string, args []interface{}, fn interface{}) error {
+	f := reflect.ValueOf(fn)
+	t := f.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 1 || t.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		return fmt.Errorf("squaresql: Each requires a func(T) error, got %T", fn)
+	}
+
+	in := t.In(0)
+	elem := in
+	if in.Kind() == reflect.Ptr {
+		elem = in.Elem()
+	}
+
+	cur, err := s.Cursor(ctx, db, name, args...)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+
+	for cur.Next() {
+		v := reflect.New(elem)
+		if err := cur.decode(v.Elem()); err != nil {
+			return err
+		}
+		if in.Kind() != reflect.Ptr {
+			v = v.Elem()
+		}
+
+		if err, _ := f.Call([]reflect.Value{v})[0].Interface().(error); err != nil {
+			if errors.Is(err, Stop) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	return cur.Close()
+}
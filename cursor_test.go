@@ -0,0 +1,185 @@
+package squaresql_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+type audit struct {
+	CreatedBy string
+}
+
+type user struct {
+	audit
+	ID       int64
+	FullName string `db:"name"`
+	Email    *string
+	Secret   string `db:"-"`
+}
+
+func TestEach(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: list-users
+	SELECT id, name, email, created_by FROM users
+
+	-- name: list-names
+	SELECT name FROM users
+	`)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	email := "bob@example.com"
+	users := func() *squaresqltest.Rows {
+		return squaresqltest.NewRows("id", "name", "email", "created_by").
+			AddRow(int64(1), "alice", nil, "admin").
+			AddRow(int64(2), "bob", email, "alice").
+			AddRow(int64(3), "carol", nil, "alice")
+	}
+
+	t.Run("structs", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("list-users").WillReturnRows(users())
+
+		var got []user
+		err := square.Each(ctx, db, "list-users", nil, func(u user) error {
+			got = append(got, u)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []user{
+			{ID: 1, FullName: "alice", audit: audit{CreatedBy: "admin"}},
+			{ID: 2, FullName: "bob", Email: &email, audit: audit{CreatedBy: "alice"}},
+			{ID: 3, FullName: "carol", audit: audit{CreatedBy: "alice"}},
+		}, got)
+	})
+
+	t.Run("pointers and stop", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("list-users").WillReturnRows(users())
+
+		var ids []int64
+		err := square.Each(ctx, db, "list-users", nil, func(u *user) error {
+			ids = append(ids, u.ID)
+			if len(ids) == 2 {
+				return squaresql.Stop
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{1, 2}, ids)
+	})
+
+	t.Run("wrapped stop", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("list-users").WillReturnRows(users())
+
+		calls := 0
+		err := square.Each(ctx, db, "list-users", nil, func(u user) error {
+			calls++
+			return fmt.Errorf("found %d: %w", u.ID, squaresql.Stop)
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("unexported embedded pointers", func(t *testing.T) {
+		type withAudit struct {
+			*audit
+			Name string
+		}
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("list-names").WillReturnRows(squaresqltest.NewRows("name").AddRow("alice"))
+		mock.ExpectQuery("list-names").WillReturnRows(squaresqltest.NewRows("name", "created_by").AddRow("alice", "admin"))
+
+		var names []string
+		err := square.Each(ctx, db, "list-names", nil, func(u withAudit) error {
+			names = append(names, u.Name)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"alice"}, names)
+
+		err = square.Each(ctx, db, "list-names", nil, func(u withAudit) error { return nil })
+		assert.EqualError(t, err, `squaresql: column "created_by" has no matching field in squaresql_test.withAudit`)
+	})
+
+	t.Run("scalars", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("list-names").WillReturnRows(squaresqltest.NewRows("name").AddRow("alice").AddRow("bob"))
+
+		var names []string
+		err := square.Each(ctx, db, "list-names", nil, func(name string) error {
+			names = append(names, name)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"alice", "bob"}, names)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("list-users").WillReturnRows(users())
+		mock.ExpectQuery("list-users").WillReturnRows(users())
+
+		failure := errors.New("disk full")
+		err := square.Each(ctx, db, "list-users", nil, func(u user) error { return failure })
+		assert.Equal(t, failure, err)
+
+		err = square.Each(ctx, db, "list-users", nil, func(name string) error { return nil })
+		assert.Error(t, err)
+
+		err = square.Each(ctx, db, "list-users", nil, func(u user) {})
+		assert.Error(t, err)
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("list-users").WillReturnRows(users())
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		calls := 0
+		err := square.Each(ctx, db, "list-users", nil, func(u user) error {
+			calls++
+			cancel()
+			return nil
+		})
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestCursor(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: list-users
+	SELECT id, name FROM users WHERE id > ?
+	`)
+	assert.NoError(t, err)
+
+	db, mock := squaresqltest.New(t, square)
+	mock.ExpectQuery("list-users").WithArgs(0).WillReturnRows(
+		squaresqltest.NewRows("id", "name").AddRow(int64(1), "alice").AddRow(int64(2), "bob"))
+
+	cur, err := square.Cursor(context.Background(), db, "list-users", 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cur.Close()
+
+	var got []user
+	for cur.Next() {
+		var u user
+		assert.NoError(t, cur.Value(&u))
+		got = append(got, u)
+	}
+	assert.NoError(t, cur.Err())
+	assert.NoError(t, cur.Close())
+	assert.Equal(t, []user{{ID: 1, FullName: "alice"}, {ID: 2, FullName: "bob"}}, got)
+	assert.Error(t, cur.Value(user{}))
+}
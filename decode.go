@@ -0,0 +1,141 @@
+package squaresql
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// isStruct reports whether rows are decoded into values of t field by field,
+// rather than by scanning their only column into the value itself.
+func isStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(scannerType) && t.PkgPath() != "time"
+}
+
+// fieldPaths maps every column to the index path of the field of t it is
+// decoded into. Fields are matched by their db tag, the snake_case form of
+// their name, or their name ignoring case; a db tag of "-" skips the field.
+// The fields of embedded structs are promoted, except those of unexported
+// embedded struct pointers, which cannot be allocated.
+func fieldPaths(t reflect.Type, columns []string) ([][]int, error) {
+	fields := make(map[string][]int)
+	collectFields(t, nil, fields)
+
+	paths := make([][]int, len(columns))
+	for i, column := range columns {
+		path, ok := fields[strings.ToLower(column)]
+		if !ok {
+			return nil, fmt.Errorf("squaresql: column %q has no matching field in %s", column, t)
+		}
+		paths[i] = path
+	}
+	return paths, nil
+}
+
+func collectFields(t reflect.Type, index []int, fields map[string][]int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("db")
+		if tag == "-" || (len(f.PkgPath) > 0 && !f.Anonymous) {
+			continue
+		}
+
+		path := append(append([]int(nil), index...), i)
+		embedded := f.Type
+		if embedded.Kind() == reflect.Ptr {
+			embedded = embedded.Elem()
+		}
+		if len(f.PkgPath) > 0 && f.Type.Kind() == reflect.Ptr {
+			continue
+		}
+		if f.Anonymous && len(tag) == 0 && isStruct(embedded) {
+			collectFields(embedded, path, fields)
+			continue
+		}
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+
+		if len(tag) > 0 {
+			fields[strings.ToLower(tag)] = path
+			continue
+		}
+		for _, name := range []string{strings.ToLower(f.Name), snakeCase(f.Name)} {
+			if _, taken := fields[name]; !taken {
+				fields[name] = path
+			}
+		}
+	}
+}
+
+// snakeCase converts a Go identifier such as UserID to user_id.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// decoder scans rows into values of a given type.
+type decoder struct {
+	typ   reflect.Type
+	paths [][]int
+}
+
+func newDecoder(t reflect.Type, columns []string) (*decoder, error) {
+	d := &decoder{typ: t}
+	if !isStruct(t) {
+		if len(columns) != 1 {
+			return nil, fmt.Errorf("squaresql: cannot decode %d columns into %s", len(columns), t)
+		}
+		return d, nil
+	}
+
+	paths, err := fieldPaths(t, columns)
+	if err != nil {
+		return nil, err
+	}
+	d.paths = paths
+	return d, nil
+}
+
+// decode scans the current row of rows into v, an addressable value of the
+// decoder's type.
+func (d *decoder) decode(rows *sql.Rows, v reflect.Value) error {
+	if d.paths == nil {
+		return rows.Scan(v.Addr().Interface())
+	}
+
+	dest := make([]interface{}, len(d.paths))
+	for i, path := range d.paths {
+		dest[i] = fieldByIndex(v, path).Addr().Interface()
+	}
+	return rows.Scan(dest...)
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex allocating nil embedded struct
+// pointers on the way.
+func fieldByIndex(v reflect.Value, path []int) reflect.Value {
+	for _, i := range path {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
@@ -0,0 +1,55 @@
+package squaresql
+
+// Dialect identifies the SQL flavour a set of queries is written for. It
+// drives how query text is tokenized, e.g. whether `$$` starts a
+// dollar-quoted body or `#` starts a comment.
+type Dialect string
+
+const (
+	// Generic is used when no dialect is configured. It accepts the union of
+	// quoting styles understood by the specific dialects.
+	Generic   Dialect = ""
+	Postgres  Dialect = "postgres"
+	MySQL     Dialect = "mysql"
+	SQLite    Dialect = "sqlite"
+	SQLServer Dialect = "sqlserver"
+)
+
+func (d Dialect) dollarQuotes() bool {
+	return d == Postgres || d == Generic
+}
+
+func (d Dialect) hashComments() bool {
+	return d == MySQL
+}
+
+func (d Dialect) nestedComments() bool {
+	return d == Postgres
+}
+
+func (d Dialect) backslashEscapes() bool {
+	return d == MySQL
+}
+
+func (d Dialect) doubleQuotedStrings() bool {
+	return d == MySQL
+}
+
+func (d Dialect) backtickIdents() bool {
+	return d == MySQL || d == SQLite || d == Generic
+}
+
+func (d Dialect) bracketIdents() bool {
+	return d == SQLite || d == SQLServer
+}
+
+// maxParams is the number of bind parameters a statement may have.
+func (d Dialect) maxParams() int {
+	switch d {
+	case Postgres, MySQL:
+		return 65535
+	case SQLServer:
+		return 2100
+	}
+	return 999
+}
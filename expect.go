@@ -0,0 +1,177 @@
+package squaresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNoRowsAffected is returned by ExecExpect when a statement expected
+	// to affect rows affected none.
+	ErrNoRowsAffected = errors.New("squaresql: no rows affected")
+	// ErrTooFewRows is returned by ExecExpect when a statement affected some,
+	// but fewer rows than expected.
+	ErrTooFewRows = errors.New("squaresql: too few rows affected")
+	// ErrTooManyRows is returned by ExecExpect when a statement affected more
+	// rows than expected.
+	ErrTooManyRows = errors.New("squaresql: too many rows affected")
+)
+
+// RowsAffectedError is returned by ExecExpect when the number of rows a
+// statement affected does not match its expect annotation. It wraps
+// ErrNoRowsAffected, ErrTooFewRows or ErrTooManyRows.
+type RowsAffectedError struct {
+	Name string
+	// Expected is the expectation as written, e.g. "at most 1".
+	Expected string
+	Affected int64
+	// RolledBack is set when the enclosing transaction was rolled back.
+	RolledBack bool
+	Err        error
+}
+
+func (e *RowsAffectedError) Error() string {
+	return fmt.Sprintf("squaresql: '%s' affected %d rows, expected %s", e.Name, e.Affected, e.Expected)
+}
+
+func (e *RowsAffectedError) Unwrap() error {
+	return e.Err
+}
+
+// rowCount is the range of rows a statement is expected to affect. A
+// negative max leaves it unbounded.
+type rowCount struct {
+	min, max int64
+	rollback bool
+}
+
+func (c rowCount) String() string {
+	switch {
+	case c.min == c.max:
+		return "exactly " + strconv.FormatInt(c.min, 10)
+	case c.max < 0:
+		return "at least " + strconv.FormatInt(c.min, 10)
+	case c.min == 0:
+		return "at most " + strconv.FormatInt(c.max, 10)
+	}
+	return fmt.Sprintf("%d to %d", c.min, c.max)
+}
+
+// check returns the sentinel error describing why affected is out of range.
+func (c rowCount) check(affected int64) error {
+	switch {
+	case affected < c.min && affected == 0:
+		return ErrNoRowsAffected
+	case affected < c.min:
+		return ErrTooFewRows
+	case c.max >= 0 && affected > c.max:
+		return ErrTooManyRows
+	}
+	return nil
+}
+
+// parseRowCount parses the value of an expect annotation: "one", "none",
+// "N", "exactly N", "at-most N" or "at-least N", optionally followed by
+// "rollback".
+func parseRowCount(annotation string) (rowCount, error) {
+	fields := strings.Fields(strings.ToLower(strings.Replace(annotation, "at-", "at ", 1)))
+	c := rowCount{}
+	if n := len(fields); n > 1 && fields[n-1] == "rollback" {
+		c.rollback = true
+		fields = fields[:n-1]
+	}
+
+	number := func(s string) (int64, error) {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid row count %s", s)
+		}
+		return n, nil
+	}
+
+	var err error
+	switch {
+	case len(fields) == 1 && fields[0] == "one":
+		c.min, c.max = 1, 1
+	case len(fields) == 1 && fields[0] == "none":
+		c.min, c.max = 0, 0
+	case len(fields) == 1:
+		c.min, err = number(fields[0])
+		c.max = c.min
+	case len(fields) == 2 && fields[0] == "exactly":
+		c.min, err = number(fields[1])
+		c.max = c.min
+	case len(fields) == 3 && fields[0] == "at" && fields[1] == "most":
+		c.max, err = number(fields[2])
+	case len(fields) == 3 && fields[0] == "at" && fields[1] == "least":
+		c.min, err = number(fields[2])
+		c.max = -1
+	default:
+		err = fmt.Errorf("unknown expectation")
+	}
+	return c, err
+}
+
+// ExecExpect runs the named statement like ExecContext and checks the rows it
+// affected against its expect annotation:
+//
+//	-- name: rename-user
+//	-- expect: one
+//	UPDATE users SET name = ? WHERE id = ?
+//
+// The annotation takes one, none, a number, exactly N, at-most N or
+// at-least N. A mismatch is reported as a *RowsAffectedError; when the
+// annotation ends with "rollback" and db is a transaction, the transaction is
+// rolled back as well.
+func (s *SquareSql) ExecExpect(ctx context.Context, db ExecerContext, name string, args ...interface{}) (sql.Result, error) {
+	q, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	annotation := q.Annotation("expect")
+	if len(annotation) == 0 {
+		return nil, fmt.Errorf("squaresql: '%s' has no expect annotation", name)
+	}
+	expected, err := parseRowCount(annotation)
+	if err != nil {
+		return nil, fmt.Errorf("squaresql: invalid expect annotation %q on '%s': %v", annotation, name, err)
+	}
+
+	var res sql.Result
+	var mismatch *RowsAffectedError
+	err = s.run(ctx, db, name, func(query string) error {
+		var err error
+		res, err = db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if err := expected.check(affected); err != nil {
+			mismatch = &RowsAffectedError{Name: name, Expected: expected.String(), Affected: affected, Err: err}
+			return mismatch
+		}
+		return nil
+	})
+
+	if mismatch != nil && err == mismatch && expected.rollback {
+		if tx, ok := db.(interface{ Rollback() error }); ok {
+			if rerr := tx.Rollback(); rerr != nil {
+				return res, fmt.Errorf("%w; rolling back: %v", mismatch, rerr)
+			}
+			mismatch.RolledBack = true
+			return res, mismatch
+		}
+	}
+	if err == nil || mismatch != nil && err == mismatch {
+		s.invalidateTagsOf(name)
+	}
+
+	return res, err
+}
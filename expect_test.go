@@ -0,0 +1,103 @@
+package squaresql_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecExpect(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		expect   string
+		affected int64
+		err      error
+		message  string
+	}{
+		{expect: "one", affected: 1},
+		{expect: "one", affected: 0, err: squaresql.ErrNoRowsAffected, message: "squaresql: 'update' affected 0 rows, expected exactly 1"},
+		{expect: "one", affected: 2, err: squaresql.ErrTooManyRows, message: "squaresql: 'update' affected 2 rows, expected exactly 1"},
+		{expect: "none", affected: 0},
+		{expect: "3", affected: 2, err: squaresql.ErrTooFewRows, message: "squaresql: 'update' affected 2 rows, expected exactly 3"},
+		{expect: "exactly 2", affected: 2},
+		{expect: "at-most 1", affected: 0},
+		{expect: "at most 1", affected: 2, err: squaresql.ErrTooManyRows, message: "squaresql: 'update' affected 2 rows, expected at most 1"},
+		{expect: "at-least 1", affected: 5},
+		{expect: "at-least 2", affected: 0, err: squaresql.ErrNoRowsAffected, message: "squaresql: 'update' affected 0 rows, expected at least 2"},
+		{expect: "every row", message: `squaresql: invalid expect annotation "every row" on 'update': unknown expectation`},
+		{expect: "some", message: `squaresql: invalid expect annotation "some" on 'update': invalid row count some`},
+		{expect: "at-most -1", message: `squaresql: invalid expect annotation "at-most -1" on 'update': invalid row count -1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expect, func(t *testing.T) {
+			square, err := squaresql.LoadFromString(`
+			-- name: update
+			-- expect: ` + tt.expect + `
+			UPDATE users SET name = ? WHERE id = ?
+			`)
+			assert.NoError(t, err)
+
+			db, mock := squaresqltest.New(t, square)
+			if tt.err != nil || len(tt.message) == 0 {
+				mock.ExpectExec("update").WithArgs("ann", 1).WillReturnResult(0, tt.affected)
+			}
+
+			res, err := square.ExecExpect(ctx, db, "update", "ann", 1)
+			if len(tt.message) == 0 {
+				assert.NoError(t, err)
+				affected, _ := res.RowsAffected()
+				assert.Equal(t, tt.affected, affected)
+				return
+			}
+			assert.EqualError(t, err, tt.message)
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err))
+				var mismatch *squaresql.RowsAffectedError
+				assert.True(t, errors.As(err, &mismatch))
+				assert.Equal(t, tt.affected, mismatch.Affected)
+				assert.Equal(t, int64(1), square.Stats()["update"].Errors)
+			}
+		})
+	}
+
+	t.Run("rollback", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(`
+		-- name: update
+		-- expect: one rollback
+		UPDATE users SET name = ? WHERE id = ?
+		`)
+		assert.NoError(t, err)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectBegin()
+		mock.ExpectExec("update").WillReturnResult(0, 3)
+		mock.ExpectRollback()
+
+		tx, err := db.BeginTx(ctx, nil)
+		assert.NoError(t, err)
+		_, err = square.ExecExpect(ctx, tx, "update", "ann", 1)
+		assert.True(t, errors.Is(err, squaresql.ErrTooManyRows))
+		var mismatch *squaresql.RowsAffectedError
+		assert.True(t, errors.As(err, &mismatch))
+		assert.True(t, mismatch.RolledBack)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("no annotation", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(`
+		-- name: update
+		UPDATE users SET name = ?
+		`)
+		assert.NoError(t, err)
+
+		db, _ := squaresqltest.New(t, square)
+		_, err = square.ExecExpect(ctx, db, "update", "ann")
+		assert.EqualError(t, err, "squaresql: 'update' has no expect annotation")
+	})
+}
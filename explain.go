@@ -0,0 +1,343 @@
+package squaresql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Plan is the execution plan of a named query.
+type Plan struct {
+	Query   string      `json:"query"`
+	Dialect Dialect     `json:"dialect"`
+	Nodes   []*PlanNode `json:"nodes"`
+}
+
+// PlanNode is a step of a Plan.
+type PlanNode struct {
+	// Operation is the step as named by the database, e.g. "Seq Scan" on
+	// PostgreSQL, the access type on MySQL or "SEARCH" on SQLite.
+	Operation string `json:"operation"`
+	Table     string `json:"table,omitempty"`
+	Index     string `json:"index,omitempty"`
+	// FullScan is set when the step reads a whole table.
+	FullScan bool `json:"fullScan,omitempty"`
+	// Rows and Cost are the estimates of the database, where available.
+	Rows     float64     `json:"rows,omitempty"`
+	Cost     float64     `json:"cost,omitempty"`
+	Detail   string      `json:"detail,omitempty"`
+	Children []*PlanNode `json:"children,omitempty"`
+}
+
+// Walk calls fn for every node of the plan, parents first.
+func (p *Plan) Walk(fn func(n *PlanNode)) {
+	var walk func(nodes []*PlanNode)
+	walk = func(nodes []*PlanNode) {
+		for _, n := range nodes {
+			fn(n)
+			walk(n.Children)
+		}
+	}
+	walk(p.Nodes)
+}
+
+// Explain runs the EXPLAIN statement of the configured dialect for the named
+// query with args and returns the plan the database chose. The query is not
+// executed.
+func (s *SquareSql) Explain(ctx context.Context, db QueryerContext, name string, args ...interface{}) (*Plan, error) {
+	q, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := s.render(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	dialect := s.Dialect()
+	var explain string
+	switch dialect {
+	case Postgres:
+		explain = "EXPLAIN (FORMAT JSON) "
+	case MySQL:
+		explain = "EXPLAIN "
+	case SQLite:
+		explain = "EXPLAIN QUERY PLAN "
+	default:
+		return nil, fmt.Errorf("squaresql: explaining queries requires a dialect")
+	}
+
+	rows, err := db.QueryContext(ctx, explain+query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("squaresql: explaining '%s': %v", name, err)
+	}
+	rs, err := readResultSet(rows)
+	if err != nil {
+		return nil, fmt.Errorf("squaresql: explaining '%s': %v", name, err)
+	}
+
+	_, prefix := s.scope()
+	plan := &Plan{Query: strings.TrimPrefix(q.Name, prefix), Dialect: dialect}
+	switch dialect {
+	case Postgres:
+		plan.Nodes, err = postgresPlan(rs)
+	case MySQL:
+		plan.Nodes, err = mysqlPlan(rs)
+	case SQLite:
+		plan.Nodes, err = sqlitePlan(rs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("squaresql: explaining '%s': %v", name, err)
+	}
+
+	return plan, nil
+}
+
+func planString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(x)
+	case string:
+		return x
+	}
+	return fmt.Sprint(v)
+}
+
+func planNumber(v interface{}) float64 {
+	f, _ := strconv.ParseFloat(planString(v), 64)
+	return f
+}
+
+type postgresNode struct {
+	NodeType     string          `json:"Node Type"`
+	RelationName string          `json:"Relation Name"`
+	IndexName    string          `json:"Index Name"`
+	PlanRows     float64         `json:"Plan Rows"`
+	TotalCost    float64         `json:"Total Cost"`
+	Plans        []*postgresNode `json:"Plans"`
+}
+
+func (n *postgresNode) node() *PlanNode {
+	p := &PlanNode{
+		Operation: n.NodeType,
+		Table:     n.RelationName,
+		Index:     n.IndexName,
+		FullScan:  n.NodeType == "Seq Scan",
+		Rows:      n.PlanRows,
+		Cost:      n.TotalCost,
+	}
+	for _, child := range n.Plans {
+		p.Children = append(p.Children, child.node())
+	}
+	return p
+}
+
+// postgresPlan parses the output of EXPLAIN (FORMAT JSON).
+func postgresPlan(rs *ResultSet) ([]*PlanNode, error) {
+	if len(rs.Rows) != 1 || len(rs.Rows[0]) != 1 {
+		return nil, fmt.Errorf("unexpected EXPLAIN output")
+	}
+
+	var plans []struct {
+		Plan *postgresNode `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(planString(rs.Rows[0][0])), &plans); err != nil {
+		return nil, err
+	}
+
+	var nodes []*PlanNode
+	for _, p := range plans {
+		if p.Plan != nil {
+			nodes = append(nodes, p.Plan.node())
+		}
+	}
+	return nodes, nil
+}
+
+// mysqlPlan parses the tabular output of EXPLAIN, a row per table access.
+func mysqlPlan(rs *ResultSet) ([]*PlanNode, error) {
+	column := func(row []interface{}, name string) interface{} {
+		if i := columnIndex(rs.Columns, name); i >= 0 {
+			return row[i]
+		}
+		return nil
+	}
+	if columnIndex(rs.Columns, "type") < 0 {
+		return nil, fmt.Errorf("unexpected EXPLAIN output")
+	}
+
+	var nodes []*PlanNode
+	for _, row := range rs.Rows {
+		access := planString(column(row, "type"))
+		nodes = append(nodes, &PlanNode{
+			Operation: access,
+			Table:     planString(column(row, "table")),
+			Index:     planString(column(row, "key")),
+			FullScan:  access == "ALL",
+			Rows:      planNumber(column(row, "rows")),
+			Detail:    planString(column(row, "Extra")),
+		})
+	}
+	return nodes, nil
+}
+
+// sqlitePlan parses the output of EXPLAIN QUERY PLAN, rows of id, parent,
+// notused and detail such as "SEARCH users USING INDEX users_email (email=?)".
+func sqlitePlan(rs *ResultSet) ([]*PlanNode, error) {
+	id, parent, detail := columnIndex(rs.Columns, "id"), columnIndex(rs.Columns, "parent"), columnIndex(rs.Columns, "detail")
+	if id < 0 || parent < 0 || detail < 0 {
+		return nil, fmt.Errorf("unexpected EXPLAIN QUERY PLAN output")
+	}
+
+	var roots []*PlanNode
+	byID := make(map[string]*PlanNode)
+	for _, row := range rs.Rows {
+		n := sqliteNode(planString(row[detail]))
+		byID[planString(row[id])] = n
+		if p, ok := byID[planString(row[parent])]; ok {
+			p.Children = append(p.Children, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+	return roots, nil
+}
+
+func sqliteNode(detail string) *PlanNode {
+	n := &PlanNode{Detail: detail}
+	fields := strings.Fields(detail)
+	if len(fields) == 0 {
+		return n
+	}
+
+	n.Operation = fields[0]
+	if n.Operation != "SCAN" && n.Operation != "SEARCH" {
+		return n
+	}
+	rest := fields[1:]
+	if len(rest) > 0 && rest[0] == "TABLE" {
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		n.Table = rest[0]
+	}
+
+	for i, f := range rest {
+		switch {
+		case f == "INDEX" && i+1 < len(rest):
+			n.Index = rest[i+1]
+		case f == "PRIMARY" && i+1 < len(rest) && rest[i+1] == "KEY":
+			n.Index = "PRIMARY KEY"
+		}
+	}
+	n.FullScan = n.Operation == "SCAN" && len(n.Index) == 0
+	return n
+}
+
+// Regression is a change of a query plan for the worse.
+type Regression struct {
+	Query  string `json:"query"`
+	Table  string `json:"table,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: %s", r.Query, r.Reason)
+}
+
+// ComparePlans reports the regressions of current compared with baseline:
+// full scans of tables baseline did not scan fully and indexes baseline used
+// that current does not. Full scans estimated to read fewer than minRows
+// rows are not reported.
+func ComparePlans(baseline, current *Plan, minRows float64) []Regression {
+	scanned := make(map[string]bool)
+	indexes := make(map[string]string)
+	baseline.Walk(func(n *PlanNode) {
+		if n.FullScan {
+			scanned[n.Table] = true
+		}
+		if len(n.Index) > 0 {
+			indexes[n.Index] = n.Table
+		}
+	})
+
+	var regressions []Regression
+	reported := make(map[string]bool)
+	current.Walk(func(n *PlanNode) {
+		if n.FullScan && !scanned[n.Table] && !reported[n.Table] && (minRows <= 0 || n.Rows >= minRows) {
+			reported[n.Table] = true
+			regressions = append(regressions, Regression{Query: current.Query, Table: n.Table, Reason: "new full scan of " + n.Table})
+		}
+		delete(indexes, n.Index)
+	})
+
+	lost := make([]string, 0, len(indexes))
+	for index := range indexes {
+		lost = append(lost, index)
+	}
+	sort.Strings(lost)
+	for _, index := range lost {
+		regressions = append(regressions, Regression{Query: current.Query, Table: indexes[index], Reason: "no longer uses index " + index})
+	}
+
+	return regressions
+}
+
+// PlanBaseline checks query plans against golden files holding the plans
+// recorded earlier, one <query>.plan.json file per query in Dir.
+type PlanBaseline struct {
+	Dir string
+	// Update records the current plans instead of comparing them.
+	Update bool
+	// MinRows is passed to ComparePlans.
+	MinRows float64
+}
+
+// CheckPlan explains the named query and compares its plan with the golden
+// file, which is written if it does not exist yet or Update is set.
+func (b *PlanBaseline) CheckPlan(ctx context.Context, square *SquareSql, db QueryerContext, name string, args ...interface{}) ([]Regression, error) {
+	current, err := square.Explain(ctx, db, name, args...)
+	if err != nil {
+		return nil, err
+	}
+	return b.Compare(current)
+}
+
+// Compare compares current with the golden file of its query, which is
+// written if it does not exist yet or Update is set.
+func (b *PlanBaseline) Compare(current *Plan) ([]Regression, error) {
+	path := filepath.Join(b.Dir, current.Query+".plan.json")
+	data, err := ioutil.ReadFile(path)
+	if b.Update || os.IsNotExist(err) {
+		return nil, writePlan(path, current)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	baseline := &Plan{}
+	if err := json.Unmarshal(data, baseline); err != nil {
+		return nil, fmt.Errorf("squaresql: reading %s: %v", path, err)
+	}
+	return ComparePlans(baseline, current, b.MinRows), nil
+}
+
+func writePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
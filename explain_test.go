@@ -0,0 +1,154 @@
+package squaresql_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+const postgresPlan = `[{"Plan": {
+	"Node Type": "Nested Loop", "Total Cost": 16.6, "Plan Rows": 1,
+	"Plans": [
+		{"Node Type": "Index Scan", "Relation Name": "users", "Index Name": "users_pkey", "Total Cost": 8.3, "Plan Rows": 1},
+		{"Node Type": "Seq Scan", "Relation Name": "orders", "Total Cost": 8.3, "Plan Rows": 1200}
+	]
+}}]`
+
+func loadExplainSquare(t *testing.T, dialect squaresql.Dialect) *squaresql.SquareSql {
+	square, err := squaresql.LoadFromString(`
+	-- name: user-orders
+	SELECT * FROM users u JOIN orders o ON o.user_id = u.id WHERE u.id = ?
+	`, squaresql.WithDialect(dialect))
+	assert.NoError(t, err)
+	return square
+}
+
+func TestExplain(t *testing.T) {
+	ctx := context.Background()
+	query := "SELECT * FROM users u JOIN orders o ON o.user_id = u.id WHERE u.id = ?"
+
+	t.Run("postgres", func(t *testing.T) {
+		square := loadExplainSquare(t, squaresql.Postgres)
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuerySQL("EXPLAIN (FORMAT JSON) " + query).WithArgs(1).
+			WillReturnRows(squaresqltest.NewRows("QUERY PLAN").AddRow(postgresPlan))
+
+		plan, err := square.Explain(ctx, db, "user-orders", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, &squaresql.Plan{
+			Query:   "user-orders",
+			Dialect: squaresql.Postgres,
+			Nodes: []*squaresql.PlanNode{{
+				Operation: "Nested Loop", Rows: 1, Cost: 16.6,
+				Children: []*squaresql.PlanNode{
+					{Operation: "Index Scan", Table: "users", Index: "users_pkey", Rows: 1, Cost: 8.3},
+					{Operation: "Seq Scan", Table: "orders", FullScan: true, Rows: 1200, Cost: 8.3},
+				},
+			}},
+		}, plan)
+	})
+
+	t.Run("mysql", func(t *testing.T) {
+		square := loadExplainSquare(t, squaresql.MySQL)
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuerySQL("EXPLAIN " + query).WithArgs(1).
+			WillReturnRows(squaresqltest.NewRows("id", "select_type", "table", "type", "key", "rows", "Extra").
+				AddRow(int64(1), "SIMPLE", "u", "const", "PRIMARY", int64(1), nil).
+				AddRow(int64(1), "SIMPLE", "o", "ALL", nil, int64(1200), []byte("Using where")))
+
+		plan, err := square.Explain(ctx, db, "user-orders", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []*squaresql.PlanNode{
+			{Operation: "const", Table: "u", Index: "PRIMARY", Rows: 1},
+			{Operation: "ALL", Table: "o", FullScan: true, Rows: 1200, Detail: "Using where"},
+		}, plan.Nodes)
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		square := loadExplainSquare(t, squaresql.SQLite)
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuerySQL("EXPLAIN QUERY PLAN " + query).WithArgs(1).
+			WillReturnRows(squaresqltest.NewRows("id", "parent", "notused", "detail").
+				AddRow(int64(2), int64(0), int64(0), "SEARCH u USING INTEGER PRIMARY KEY (rowid=?)").
+				AddRow(int64(3), int64(0), int64(0), "SCAN o"))
+
+		plan, err := square.Explain(ctx, db, "user-orders", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []*squaresql.PlanNode{
+			{Operation: "SEARCH", Table: "u", Index: "PRIMARY KEY", Detail: "SEARCH u USING INTEGER PRIMARY KEY (rowid=?)"},
+			{Operation: "SCAN", Table: "o", FullScan: true, Detail: "SCAN o"},
+		}, plan.Nodes)
+	})
+
+	t.Run("generic", func(t *testing.T) {
+		square := loadExplainSquare(t, squaresql.Generic)
+		db, _ := squaresqltest.New(t, square)
+		_, err := square.Explain(ctx, db, "user-orders", 1)
+		assert.Error(t, err)
+	})
+}
+
+func TestComparePlans(t *testing.T) {
+	baseline := &squaresql.Plan{Query: "q", Nodes: []*squaresql.PlanNode{
+		{Operation: "Index Scan", Table: "users", Index: "users_pkey"},
+		{Operation: "Seq Scan", Table: "tags", FullScan: true, Rows: 10},
+	}}
+	current := &squaresql.Plan{Query: "q", Nodes: []*squaresql.PlanNode{{
+		Operation: "Hash Join",
+		Children: []*squaresql.PlanNode{
+			{Operation: "Seq Scan", Table: "users", FullScan: true, Rows: 50000},
+			{Operation: "Seq Scan", Table: "tags", FullScan: true, Rows: 10},
+			{Operation: "Seq Scan", Table: "flags", FullScan: true, Rows: 5},
+		},
+	}}}
+
+	assert.Equal(t, []squaresql.Regression{
+		{Query: "q", Table: "users", Reason: "new full scan of users"},
+		{Query: "q", Table: "flags", Reason: "new full scan of flags"},
+		{Query: "q", Table: "users", Reason: "no longer uses index users_pkey"},
+	}, squaresql.ComparePlans(baseline, current, 0))
+
+	assert.Equal(t, []squaresql.Regression{
+		{Query: "q", Table: "users", Reason: "new full scan of users"},
+		{Query: "q", Table: "users", Reason: "no longer uses index users_pkey"},
+	}, squaresql.ComparePlans(baseline, current, 1000))
+
+	assert.Empty(t, squaresql.ComparePlans(current, current, 0))
+}
+
+func TestPlanBaseline(t *testing.T) {
+	ctx := context.Background()
+	square := loadExplainSquare(t, squaresql.SQLite)
+	query := "EXPLAIN QUERY PLAN SELECT * FROM users u JOIN orders o ON o.user_id = u.id WHERE u.id = ?"
+	indexed := func() *squaresqltest.Rows {
+		return squaresqltest.NewRows("id", "parent", "notused", "detail").
+			AddRow(int64(2), int64(0), int64(0), "SEARCH u USING INTEGER PRIMARY KEY (rowid=?)").
+			AddRow(int64(3), int64(0), int64(0), "SEARCH o USING INDEX orders_user_id (user_id=?)")
+	}
+
+	db, mock := squaresqltest.New(t, square)
+	mock.ExpectQuerySQL(query).WillReturnRows(indexed())
+	mock.ExpectQuerySQL(query).WillReturnRows(indexed())
+	mock.ExpectQuerySQL(query).WillReturnRows(squaresqltest.NewRows("id", "parent", "notused", "detail").
+		AddRow(int64(2), int64(0), int64(0), "SEARCH u USING INTEGER PRIMARY KEY (rowid=?)").
+		AddRow(int64(3), int64(0), int64(0), "SCAN o"))
+
+	baseline := &squaresql.PlanBaseline{Dir: t.TempDir()}
+
+	// The first check records the plan, the second finds it unchanged.
+	for i := 0; i < 2; i++ {
+		regressions, err := baseline.CheckPlan(ctx, square, db, "user-orders", 1)
+		assert.NoError(t, err)
+		assert.Empty(t, regressions)
+	}
+
+	regressions, err := baseline.CheckPlan(ctx, square, db, "user-orders", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []squaresql.Regression{
+		{Query: "user-orders", Table: "o", Reason: "new full scan of o"},
+		{Query: "user-orders", Table: "o", Reason: "no longer uses index orders_user_id"},
+	}, regressions)
+}
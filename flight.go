@@ -0,0 +1,72 @@
+package squaresql
+
+import (
+	"sync"
+)
+
+// flight is a Fetch in progress that identical concurrent calls wait for.
+type flight struct {
+	done chan struct{}
+	rs   *ResultSet
+	err  error
+}
+
+// flightGroup collapses concurrent calls sharing a key into one.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// do calls fn unless a call for key is already in flight, in which case it
+// waits for that call and shares its result.
+func (g *flightGroup) do(key string, fn func() (*ResultSet, error)) (*ResultSet, bool, error) {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		<-f.done
+		return f.rs, true, f.err
+	}
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+
+	f.rs, f.err = fn()
+	return f.rs, false, f.err
+}
+
+// SetSingleflight sets whether concurrent Fetch calls of the same query with
+// identical arguments are collapsed into a single database round trip whose
+// result is shared between the callers. Queries can opt in or out with a
+// `-- singleflight: true|false` annotation regardless of this setting.
+//
+// Callers sharing a result also share the error of the call that ran,
+// including the cancellation of its context.
+func (s *SquareSql) SetSingleflight(enabled bool) {
+	root, _ := s.scope()
+	root.flightMu.Lock()
+	root.singleflight = enabled
+	root.flightMu.Unlock()
+}
+
+// collapses reports whether concurrent Fetch calls of q are collapsed.
+func (s *SquareSql) collapses(q *Query) (bool, error) {
+	if enabled, ok, err := q.flag("singleflight"); ok || err != nil {
+		return enabled, err
+	}
+
+	root, _ := s.scope()
+	root.flightMu.Lock()
+	defer root.flightMu.Unlock()
+
+	return root.singleflight, nil
+}
@@ -0,0 +1,152 @@
+package squaresql_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchSingleflight(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: get-user
+	SELECT id, name FROM users WHERE id = ?
+
+	-- name: list-users
+	-- singleflight: true
+	SELECT id, name FROM users
+
+	-- name: broken
+	-- singleflight: sometimes
+	SELECT 1
+	`)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	fetchConcurrently := func(t *testing.T, db squaresql.QueryerContext, name string, args ...interface{}) {
+		var (
+			start = make(chan struct{})
+			wg    sync.WaitGroup
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				rs, err := square.Fetch(ctx, db, name, args...)
+				assert.NoError(t, err)
+				assert.Equal(t, [][]interface{}{{int64(1), "alice"}}, rs.Rows)
+			}()
+		}
+		close(start)
+		wg.Wait()
+	}
+
+	t.Run("annotated", func(t *testing.T) {
+		square.ResetStats()
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("list-users").
+			WillDelayFor(200 * time.Millisecond).
+			WillReturnRows(squaresqltest.NewRows("id", "name").AddRow(int64(1), "alice"))
+
+		fetchConcurrently(t, db, "list-users")
+
+		stats := square.Stats()["list-users"]
+		assert.Equal(t, int64(1), stats.Calls)
+		assert.Equal(t, int64(9), stats.Collapsed)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		square.SetSingleflight(true)
+		defer square.SetSingleflight(false)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("get-user").WithArgs(1).
+			WillDelayFor(200 * time.Millisecond).
+			WillReturnRows(squaresqltest.NewRows("id", "name").AddRow(int64(1), "alice"))
+
+		fetchConcurrently(t, db, "get-user", 1)
+	})
+
+	t.Run("pointer args with different values", func(t *testing.T) {
+		square.SetSingleflight(true)
+		defer square.SetSingleflight(false)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.InAnyOrder()
+		names := []string{"alice", "bob"}
+		for i, name := range names {
+			mock.ExpectQuery("get-user").WithArgs(i + 1).
+				WillDelayFor(200 * time.Millisecond).
+				WillReturnRows(squaresqltest.NewRows("id", "name").AddRow(int64(i+1), name))
+		}
+
+		var wg sync.WaitGroup
+		for i := range names {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				id := i + 1
+				rs, err := square.Fetch(ctx, db, "get-user", &id)
+				assert.NoError(t, err)
+				assert.Equal(t, [][]interface{}{{int64(id), names[i]}}, rs.Rows)
+			}(i)
+		}
+		wg.Wait()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("pointer args with equal values", func(t *testing.T) {
+		square.SetSingleflight(true)
+		defer square.SetSingleflight(false)
+		square.ResetStats()
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("get-user").WithArgs(1).
+			WillDelayFor(200 * time.Millisecond).
+			WillReturnRows(squaresqltest.NewRows("id", "name").AddRow(int64(1), "alice"))
+
+		var (
+			start = make(chan struct{})
+			wg    sync.WaitGroup
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				id := 1
+				<-start
+				rs, err := square.Fetch(ctx, db, "get-user", &id)
+				assert.NoError(t, err)
+				assert.Equal(t, [][]interface{}{{int64(1), "alice"}}, rs.Rows)
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		assert.Equal(t, int64(9), square.Stats()["get-user"].Collapsed)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		for i := 0; i < 2; i++ {
+			mock.ExpectQuery("get-user").WithArgs(1).
+				WillReturnRows(squaresqltest.NewRows("id", "name").AddRow(int64(1), "alice"))
+		}
+
+		for i := 0; i < 2; i++ {
+			_, err := square.Fetch(ctx, db, "get-user", 1)
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("invalid annotation", func(t *testing.T) {
+		db, _ := squaresqltest.New(t, square)
+		_, err := square.Fetch(ctx, db, "broken")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,184 @@
+package squaresql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var placeholderRe = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+type identifiersKey struct{}
+
+// WithIdentifiers returns a context providing values for the identifier
+// placeholders of queries, such as {{schema}} in
+//
+//	SELECT * FROM {{schema}}.orders WHERE id = ?
+//
+// They take precedence over the values of a parent context and those set
+// with SetIdentifiers.
+func WithIdentifiers(ctx context.Context, identifiers map[string]string) context.Context {
+	merged := make(map[string]string)
+	for name, value := range identifiersFrom(ctx) {
+		merged[name] = value
+	}
+	for name, value := range identifiers {
+		merged[name] = value
+	}
+	return context.WithValue(ctx, identifiersKey{}, merged)
+}
+
+func identifiersFrom(ctx context.Context) map[string]string {
+	identifiers, _ := ctx.Value(identifiersKey{}).(map[string]string)
+	return identifiers
+}
+
+// SetIdentifiers sets the values used for identifier placeholders a context
+// passed to a query provides no value for.
+func (s *SquareSql) SetIdentifiers(identifiers map[string]string) {
+	defaults := make(map[string]string, len(identifiers))
+	for name, value := range identifiers {
+		defaults[name] = value
+	}
+
+	root, _ := s.scope()
+	root.identMu.Lock()
+	root.identifiers = defaults
+	root.identMu.Unlock()
+}
+
+// identifier returns the value of the placeholder name for ctx.
+func (s *SquareSql) identifier(ctx context.Context, name string) (string, bool) {
+	if value, ok := identifiersFrom(ctx)[name]; ok {
+		return value, true
+	}
+
+	root, _ := s.scope()
+	root.identMu.Lock()
+	defer root.identMu.Unlock()
+
+	value, ok := root.identifiers[name]
+	return value, ok
+}
+
+// replacePlaceholders replaces every placeholder of query outside string
+// literals, quoted identifiers and comments with the result of replace.
+func replacePlaceholders(query string, dialect Dialect, replace func(name string) string) string {
+	var b, code strings.Builder
+	flush := func() {
+		b.WriteString(placeholderRe.ReplaceAllStringFunc(code.String(), func(placeholder string) string {
+			return replace(placeholderRe.FindStringSubmatch(placeholder)[1])
+		}))
+		code.Reset()
+	}
+
+	for _, t := range tokenize(query, dialect) {
+		switch t.kind {
+		case tokenString, tokenComment, tokenQuotedIdent, tokenDollar:
+			flush()
+			b.WriteString(t.text)
+		default:
+			code.WriteString(t.text)
+		}
+	}
+	flush()
+
+	return b.String()
+}
+
+// placeholders returns the names of the placeholders of query in order of
+// first appearance.
+func placeholders(query string, dialect Dialect) []string {
+	var names []string
+	seen := make(map[string]bool)
+	replacePlaceholders(query, dialect, func(name string) string {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return ""
+	})
+	return names
+}
+
+// QuoteIdentifier quotes name as an identifier of dialect: with backticks on
+// MySQL, brackets on SQL Server and double quotes otherwise. name is not
+// escaped and must not contain the quote characters.
+func QuoteIdentifier(name string, dialect Dialect) string {
+	switch dialect {
+	case MySQL:
+		return "`" + name + "`"
+	case SQLServer:
+		return "[" + name + "]"
+	}
+	return `"` + name + `"`
+}
+
+// maxRendered is the number of statements kept per query by render. Once
+// reached, the statements are dropped and rendered again as needed, so that a
+// query run for many tenants does not grow the cache without bound.
+const maxRendered = 256
+
+// renderedQuery holds the statements rendered from q keyed by the dialect
+// and the values of its placeholders.
+type renderedQuery struct {
+	q          *Query
+	statements map[string]string
+}
+
+// render returns the statement run for q with ctx, its placeholders replaced
+// by the quoted values provided by ctx or SetIdentifiers. Values must be
+// plain identifiers of letters, digits and underscores. Rendered statements
+// are kept per dialect and set of values until the query changes, up to
+// maxRendered of them.
+func (s *SquareSql) render(ctx context.Context, q *Query) (string, error) {
+	if len(q.placeholders) == 0 {
+		return q.text(), nil
+	}
+
+	dialect := q.Dialect
+	if dialect == Generic {
+		dialect = s.Dialect()
+	}
+	values := make(map[string]string, len(q.placeholders))
+	key := make([]string, len(q.placeholders)+1)
+	key[0] = string(dialect)
+	for i, name := range q.placeholders {
+		value, ok := s.identifier(ctx, name)
+		if !ok {
+			return "", fmt.Errorf("squaresql: no value for {{%s}} in '%s'", name, q.Name)
+		}
+		if !identifierRe.MatchString(value) {
+			return "", fmt.Errorf("squaresql: invalid identifier %q for {{%s}} in '%s'", value, name, q.Name)
+		}
+		values[name] = value
+		key[i+1] = value
+	}
+
+	root, _ := s.scope()
+	root.identMu.Lock()
+	defer root.identMu.Unlock()
+
+	rendered := root.rendered[q.Name]
+	if rendered == nil || rendered.q != q {
+		rendered = &renderedQuery{q: q, statements: make(map[string]string)}
+		if root.rendered == nil {
+			root.rendered = make(map[string]*renderedQuery)
+		}
+		root.rendered[q.Name] = rendered
+	}
+	if statement, ok := rendered.statements[strings.Join(key, "\x00")]; ok {
+		return statement, nil
+	}
+	if len(rendered.statements) >= maxRendered {
+		rendered.statements = make(map[string]string)
+	}
+
+	statement := replacePlaceholders(q.text(), dialect, func(name string) string {
+		return QuoteIdentifier(values[name], dialect)
+	})
+	rendered.statements[strings.Join(key, "\x00")] = statement
+
+	return statement, nil
+}
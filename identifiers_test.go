@@ -0,0 +1,163 @@
+package squaresql_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentifierPlaceholders(t *testing.T) {
+	queries := `
+	-- name: orders
+	-- cache: 1m
+	SELECT * FROM {{schema}}.orders WHERE note <> '{{schema}}' -- {{ schema }}
+
+	-- name: archive
+	INSERT INTO {{ schema }}.{{table}} SELECT * FROM {{schema}}.orders
+	`
+	tenantA := squaresql.WithIdentifiers(context.Background(), map[string]string{"schema": "tenant_a"})
+
+	t.Run("quoting per dialect", func(t *testing.T) {
+		tests := []struct {
+			dialect squaresql.Dialect
+			want    string
+		}{
+			{squaresql.Postgres, `INSERT INTO "tenant_a"."orders_2020" SELECT * FROM "tenant_a".orders`},
+			{squaresql.MySQL, "INSERT INTO `tenant_a`.`orders_2020` SELECT * FROM `tenant_a`.orders"},
+			{squaresql.SQLServer, "INSERT INTO [tenant_a].[orders_2020] SELECT * FROM [tenant_a].orders"},
+		}
+		for _, tt := range tests {
+			t.Run(string(tt.dialect), func(t *testing.T) {
+				square, err := squaresql.LoadFromString(queries, squaresql.WithDialect(tt.dialect))
+				assert.NoError(t, err)
+
+				db, mock := squaresqltest.New(t, square)
+				mock.ExpectExecSQL(tt.want).WillReturnResult(0, 1)
+
+				ctx := squaresql.WithIdentifiers(tenantA, map[string]string{"table": "orders_2020"})
+				_, err = square.ExecContext(ctx, db, "archive")
+				assert.NoError(t, err)
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	})
+
+	t.Run("dialect changes", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(queries, squaresql.WithDialect(squaresql.Postgres))
+		assert.NoError(t, err)
+		ctx := squaresql.WithIdentifiers(tenantA, map[string]string{"table": "orders_2020"})
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectExecSQL(`INSERT INTO "tenant_a"."orders_2020" SELECT * FROM "tenant_a".orders`).WillReturnResult(0, 1)
+		mock.ExpectExecSQL("INSERT INTO `tenant_a`.`orders_2020` SELECT * FROM `tenant_a`.orders").WillReturnResult(0, 1)
+
+		_, err = square.ExecContext(ctx, db, "archive")
+		assert.NoError(t, err)
+		square.SetDialect(squaresql.MySQL)
+		_, err = square.ExecContext(ctx, db, "archive")
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("literals and comments are kept", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(queries, squaresql.WithDialect(squaresql.Postgres))
+		assert.NoError(t, err)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuerySQL(`SELECT * FROM "tenant_a".orders WHERE note <> '{{schema}}' -- {{ schema }}`).
+			WillReturnRows(squaresqltest.NewRows("id"))
+
+		rows, err := square.QueryContext(tenantA, db, "orders")
+		assert.NoError(t, err)
+		assert.NoError(t, rows.Close())
+
+		raw, err := square.Raw("orders")
+		assert.NoError(t, err)
+		assert.Contains(t, raw, "FROM {{schema}}.orders", "Raw returns the query as declared")
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(queries, squaresql.WithDialect(squaresql.Postgres))
+		assert.NoError(t, err)
+		square.SetIdentifiers(map[string]string{"schema": "public", "table": "orders_archive"})
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectExecSQL(`INSERT INTO "public"."orders_archive" SELECT * FROM "public".orders`).WillReturnResult(0, 1)
+		mock.ExpectExecSQL(`INSERT INTO "tenant_a"."orders_archive" SELECT * FROM "tenant_a".orders`).WillReturnResult(0, 1)
+
+		_, err = square.ExecContext(context.Background(), db, "archive")
+		assert.NoError(t, err)
+		_, err = square.ExecContext(tenantA, db, "archive")
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("prepared statements", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(queries, squaresql.WithDialect(squaresql.Postgres))
+		assert.NoError(t, err)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectPrepareSQL(`SELECT * FROM "tenant_a".orders WHERE note <> '{{schema}}' -- {{ schema }}`)
+		mock.ExpectPrepareSQL(`SELECT * FROM "public".orders WHERE note <> '{{schema}}' -- {{ schema }}`)
+
+		stmt, err := square.PrepareContext(tenantA, db, "orders")
+		if assert.NoError(t, err) {
+			assert.NoError(t, stmt.Close())
+		}
+		_, err = square.Prepare(db, "orders")
+		assert.EqualError(t, err, "squaresql: no value for {{schema}} in 'orders'")
+
+		square.SetIdentifiers(map[string]string{"schema": "public"})
+		stmt, err = square.Prepare(db, "orders")
+		if assert.NoError(t, err) {
+			assert.NoError(t, stmt.Close())
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("cached results per tenant", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(queries, squaresql.WithDialect(squaresql.Postgres))
+		assert.NoError(t, err)
+		square.SetCache(squaresql.NewLRUCache(0))
+		tenantB := squaresql.WithIdentifiers(context.Background(), map[string]string{"schema": "tenant_b"})
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuerySQL(`SELECT * FROM "tenant_a".orders WHERE note <> '{{schema}}' -- {{ schema }}`).
+			WillReturnRows(squaresqltest.NewRows("id").AddRow(int64(1)))
+		mock.ExpectQuerySQL(`SELECT * FROM "tenant_b".orders WHERE note <> '{{schema}}' -- {{ schema }}`).
+			WillReturnRows(squaresqltest.NewRows("id").AddRow(int64(2)))
+
+		for i := 0; i < 2; i++ {
+			a, err := square.Fetch(tenantA, db, "orders")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), a.Rows[0][0])
+			b, err := square.Fetch(tenantB, db, "orders")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(2), b.Rows[0][0])
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(queries, squaresql.WithDialect(squaresql.Postgres))
+		assert.NoError(t, err)
+		db, _ := squaresqltest.New(t, square)
+
+		_, err = square.ExecContext(tenantA, db, "archive")
+		assert.EqualError(t, err, "squaresql: no value for {{table}} in 'archive'")
+
+		ctx := squaresql.WithIdentifiers(tenantA, map[string]string{"table": `orders"; DROP TABLE users; --`})
+		_, err = square.ExecContext(ctx, db, "archive")
+		assert.EqualError(t, err, `squaresql: invalid identifier "orders\"; DROP TABLE users; --" for {{table}} in 'archive'`)
+	})
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, `"orders"`, squaresql.QuoteIdentifier("orders", squaresql.Postgres))
+	assert.Equal(t, `"orders"`, squaresql.QuoteIdentifier("orders", squaresql.Generic))
+	assert.Equal(t, "`orders`", squaresql.QuoteIdentifier("orders", squaresql.MySQL))
+	assert.Equal(t, "[orders]", squaresql.QuoteIdentifier("orders", squaresql.SQLServer))
+}
@@ -0,0 +1,250 @@
+package squaresql
+
+import (
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenSpace tokenKind = iota
+	tokenComment
+	tokenString
+	tokenQuotedIdent
+	tokenDollar
+	tokenWord
+	tokenNumber
+	tokenParam
+	tokenPunct
+)
+
+// token is a lexical unit of SQL text. Concatenating the text of every token
+// returned by tokenize yields the original input.
+type token struct {
+	kind tokenKind
+	text string
+	// open is set when a string, identifier, comment or dollar-quoted body
+	// reaches the end of input before it is closed.
+	open bool
+}
+
+func (t token) is(word string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, word)
+}
+
+func (t token) significant() bool {
+	return t.kind != tokenSpace && t.kind != tokenComment
+}
+
+type lexer struct {
+	src     string
+	pos     int
+	dialect Dialect
+}
+
+func tokenize(src string, dialect Dialect) []token {
+	l := &lexer{src: src, dialect: dialect}
+	var tokens []token
+	for l.pos < len(l.src) {
+		tokens = append(tokens, l.next())
+	}
+	return tokens
+}
+
+func (l *lexer) peek(offset int) byte {
+	if l.pos+offset < len(l.src) {
+		return l.src[l.pos+offset]
+	}
+	return 0
+}
+
+func (l *lexer) emit(kind tokenKind, start int, open bool) token {
+	return token{kind: kind, text: l.src[start:l.pos], open: open}
+}
+
+func (l *lexer) next() token {
+	start := l.pos
+	c := l.src[l.pos]
+
+	switch {
+	case isSpace(c):
+		for l.pos < len(l.src) && isSpace(l.src[l.pos]) {
+			l.pos++
+		}
+		return l.emit(tokenSpace, start, false)
+	case c == '-' && l.peek(1) == '-', c == '#' && l.dialect.hashComments():
+		return l.lineComment()
+	case c == '/' && l.peek(1) == '*':
+		return l.blockComment()
+	case c == '\'':
+		return l.quoted(tokenString, '\'', l.dialect.backslashEscapes())
+	case (c == 'E' || c == 'e') && l.peek(1) == '\'' && l.dialect == Postgres:
+		l.pos++
+		t := l.quoted(tokenString, '\'', true)
+		t.text = l.src[start:l.pos]
+		return t
+	case c == '"' && l.dialect.doubleQuotedStrings():
+		return l.quoted(tokenString, '"', true)
+	case c == '"':
+		return l.quoted(tokenQuotedIdent, '"', false)
+	case c == '`' && l.dialect.backtickIdents():
+		return l.quoted(tokenQuotedIdent, '`', false)
+	case c == '[' && l.dialect.bracketIdents():
+		return l.quoted(tokenQuotedIdent, ']', false)
+	case c == '$':
+		return l.dollar()
+	case c == '?':
+		l.pos++
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return l.emit(tokenParam, start, false)
+	case (c == ':' || c == '@') && isWordStart(l.peek(1)) && (start == 0 || l.src[start-1] != ':'):
+		l.pos++
+		l.word()
+		return l.emit(tokenParam, start, false)
+	case c == ':' && l.peek(1) == ':':
+		l.pos += 2
+		return l.emit(tokenPunct, start, false)
+	case isDigit(c), c == '.' && isDigit(l.peek(1)):
+		return l.number()
+	case isWordStart(c):
+		l.word()
+		return l.emit(tokenWord, start, false)
+	}
+
+	l.pos++
+	return l.emit(tokenPunct, start, false)
+}
+
+func (l *lexer) lineComment() token {
+	start := l.pos
+	for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+		l.pos++
+	}
+	return l.emit(tokenComment, start, false)
+}
+
+func (l *lexer) blockComment() token {
+	start := l.pos
+	l.pos += 2
+	depth := 1
+	for l.pos < len(l.src) {
+		switch {
+		case l.src[l.pos] == '*' && l.peek(1) == '/':
+			l.pos += 2
+			depth--
+			if depth == 0 || !l.dialect.nestedComments() {
+				return l.emit(tokenComment, start, false)
+			}
+		case l.src[l.pos] == '/' && l.peek(1) == '*' && l.dialect.nestedComments():
+			l.pos += 2
+			depth++
+		default:
+			l.pos++
+		}
+	}
+	return l.emit(tokenComment, start, true)
+}
+
+// quoted consumes a literal delimited by the current character and closing.
+// A doubled closing character is an escaped occurrence of it.
+func (l *lexer) quoted(kind tokenKind, closing byte, backslash bool) token {
+	start := l.pos
+	l.pos++
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case backslash && c == '\\':
+			l.pos += 2
+		case c == closing && l.peek(1) == closing:
+			l.pos += 2
+		case c == closing:
+			l.pos++
+			return l.emit(kind, start, false)
+		default:
+			l.pos++
+		}
+	}
+	if l.pos > len(l.src) {
+		l.pos = len(l.src)
+	}
+	return l.emit(kind, start, true)
+}
+
+// dollar consumes a positional parameter ($1), a dollar-quoted body
+// ($$...$$ or $tag$...$tag$) or a lone dollar sign.
+func (l *lexer) dollar() token {
+	start := l.pos
+	l.pos++
+	if isDigit(l.peek(0)) {
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return l.emit(tokenParam, start, false)
+	}
+
+	if !l.dialect.dollarQuotes() {
+		if isWordStart(l.peek(0)) {
+			l.word()
+			return l.emit(tokenParam, start, false)
+		}
+		return l.emit(tokenPunct, start, false)
+	}
+
+	end := l.pos
+	for end < len(l.src) && isWordPart(l.src[end]) && l.src[end] != '$' {
+		end++
+	}
+	if end >= len(l.src) || l.src[end] != '$' || (end > l.pos && !isWordStart(l.src[l.pos])) {
+		return l.emit(tokenPunct, start, false)
+	}
+
+	tag := l.src[start : end+1]
+	l.pos = end + 1
+	if i := strings.Index(l.src[l.pos:], tag); i >= 0 {
+		l.pos += i + len(tag)
+		return l.emit(tokenDollar, start, false)
+	}
+	l.pos = len(l.src)
+	return l.emit(tokenDollar, start, true)
+}
+
+func (l *lexer) number() token {
+	start := l.pos
+	for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+		l.pos++
+	}
+	if c := l.peek(0); c == 'e' || c == 'E' {
+		next := l.peek(1)
+		if isDigit(next) || ((next == '+' || next == '-') && isDigit(l.peek(2))) {
+			l.pos += 2
+			for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+				l.pos++
+			}
+		}
+	}
+	return l.emit(tokenNumber, start, false)
+}
+
+func (l *lexer) word() {
+	for l.pos < len(l.src) && isWordPart(l.src[l.pos]) {
+		l.pos++
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isWordPart(c byte) bool {
+	return isWordStart(c) || isDigit(c) || c == '$'
+}
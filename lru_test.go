@@ -0,0 +1,33 @@
+package squaresql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := NewLRUCache(2)
+	cache.now = func() time.Time { return now }
+
+	a, b, c := &ResultSet{}, &ResultSet{}, &ResultSet{}
+	cache.Set("a", a, time.Minute)
+	cache.Set("b", b, time.Second)
+
+	rs, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Same(t, a, rs)
+
+	// b is now the least recently used entry.
+	cache.Set("c", c, time.Minute)
+	assert.Equal(t, 2, cache.Len())
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, cache.Len())
+}
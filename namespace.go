@@ -0,0 +1,109 @@
+package squaresql
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NamespaceSeparator joins a namespace and a query name, as in
+// "users.get-by-id".
+const NamespaceSeparator = "."
+
+// Namespace returns the namespace of a qualified query name, i.e. everything
+// before the last separator.
+func Namespace(name string) string {
+	if i := strings.LastIndex(name, NamespaceSeparator); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+func qualify(namespace, name string) string {
+	if len(namespace) == 0 {
+		return name
+	}
+	return namespace + NamespaceSeparator + name
+}
+
+func fileNamespace(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// scope returns the SquareSql owning the queries together with the prefix
+// names are qualified with in this view.
+func (s *SquareSql) scope() (*SquareSql, string) {
+	if s.parent == nil {
+		return s, ""
+	}
+	return s.parent, s.namespace + NamespaceSeparator
+}
+
+// snapshot returns the query sets visible in this view keyed by relative
+// name, together with the configured dialect.
+func (s *SquareSql) snapshot() (map[string]querySet, Dialect) {
+	root, prefix := s.scope()
+	root.mu.RLock()
+	defer root.mu.RUnlock()
+
+	sets := make(map[string]querySet, len(root.queries))
+	for name, set := range root.queries {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if len(prefix) == 0 {
+			sets[name] = set
+			continue
+		}
+
+		relativeName := strings.TrimPrefix(name, prefix)
+		relative := make(querySet, len(set))
+		for dialect, q := range set {
+			q = q.clone()
+			q.Name = relativeName
+			relative[dialect] = q
+		}
+		sets[relativeName] = relative
+	}
+
+	return sets, root.dialect
+}
+
+// Sub returns a view of the queries in namespace. Names passed to the view
+// are resolved relative to namespace, and changes made through the view are
+// visible to s and vice versa.
+func (s *SquareSql) Sub(namespace string) *SquareSql {
+	root, prefix := s.scope()
+	return &SquareSql{parent: root, namespace: prefix + namespace}
+}
+
+// Names returns the sorted names of the queries visible in s.
+func (s *SquareSql) Names() []string {
+	sets, _ := s.snapshot()
+
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Namespaces returns the sorted namespaces of the queries visible in s.
+// Queries that are not namespaced are reported under the empty namespace.
+func (s *SquareSql) Namespaces() []string {
+	seen := make(map[string]bool)
+	var namespaces []string
+	for _, name := range s.Names() {
+		ns := Namespace(name)
+		if !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+
+	return namespaces
+}
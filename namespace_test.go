@@ -0,0 +1,96 @@
+package squaresql
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceFromFileAndHeader(t *testing.T) {
+	dir := t.TempDir()
+	usersFile := filepath.Join(dir, "users.sql")
+	ordersFile := filepath.Join(dir, "orders.sql")
+	assert.NoError(t, ioutil.WriteFile(usersFile, []byte("-- name: get-by-id\nSELECT * FROM users WHERE id = ?"), 0600))
+	assert.NoError(t, ioutil.WriteFile(ordersFile, []byte("-- namespace: billing.orders\n\n-- name: get-by-id\nSELECT * FROM orders WHERE id = ?"), 0600))
+
+	users, err := LoadFromFile(usersFile, WithFileNamespace())
+	assert.NoError(t, err)
+	orders, err := LoadFromFile(ordersFile, WithFileNamespace())
+	assert.NoError(t, err)
+	plain, err := LoadFromFile(usersFile)
+	assert.NoError(t, err)
+
+	assert.Equal(t, plain.Names(), []string{"get-by-id"})
+
+	merged := Merge(users, orders)
+	assert.Equal(t, merged.QueryMap(), map[string]string{
+		"users.get-by-id":          "SELECT * FROM users WHERE id = ?",
+		"billing.orders.get-by-id": "SELECT * FROM orders WHERE id = ?",
+	})
+	assert.Equal(t, merged.Namespaces(), []string{"billing.orders", "users"})
+
+	var got string
+	queryer := &QueryerMock{
+		QueryFunc: func(query string, _ ...interface{}) (*sql.Rows, error) {
+			got = query
+			return &sql.Rows{}, nil
+		},
+	}
+	_, err = merged.Query(queryer, "users.get-by-id", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, got, "SELECT * FROM users WHERE id = ?")
+}
+
+func TestSub(t *testing.T) {
+	square, err := LoadFromString(`
+	-- name: ping
+	SELECT 1
+	`)
+	assert.NoError(t, err)
+
+	users, err := LoadFromString(`
+	-- name: get-by-id
+	SELECT * FROM users WHERE id = ?
+	-- name: admin.list
+	SELECT * FROM users WHERE admin
+	`, WithNamespace("users"))
+	assert.NoError(t, err)
+
+	square = Merge(square, users)
+	sub := square.Sub("users")
+
+	raw, err := sub.Raw("get-by-id")
+	assert.NoError(t, err)
+	assert.Equal(t, raw, "SELECT * FROM users WHERE id = ?")
+
+	_, err = sub.Raw("ping")
+	assert.Error(t, err)
+
+	assert.Equal(t, sub.Names(), []string{"admin.list", "get-by-id"})
+	assert.Equal(t, sub.Namespaces(), []string{"", "admin"})
+	assert.Equal(t, sub.Sub("admin").QueryMap(), map[string]string{"list": "SELECT * FROM users WHERE admin"})
+
+	query, err := sub.Lookup("get-by-id")
+	assert.NoError(t, err)
+	assert.Equal(t, query.Name, "get-by-id")
+
+	sub.Add("count", "SELECT count(*) FROM users")
+	raw, err = square.Raw("users.count")
+	assert.NoError(t, err)
+	assert.Equal(t, raw, "SELECT count(*) FROM users")
+
+	sub.Replace(map[string]string{"all": "SELECT * FROM users"})
+	assert.Equal(t, square.QueryMap(), map[string]string{
+		"ping":      "SELECT 1",
+		"users.all": "SELECT * FROM users",
+	})
+
+	sub.Remove("all")
+	assert.Equal(t, square.Names(), []string{"ping"})
+
+	sub.SetDialect(Postgres)
+	assert.Equal(t, square.Dialect(), Postgres)
+}
@@ -0,0 +1,209 @@
+package squaresql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// Normalize returns the canonical form of a statement used for
+// fingerprinting: comments are dropped, literals and bind parameters are
+// replaced with ?, lists of them are collapsed, keywords and identifiers are
+// folded to lower case, quoted identifiers losing their quotes unless they
+// need them, identifier placeholders are replaced with {{}} and tokens are
+// separated by single spaces. Two statements differing only in those
+// respects, such as a named query and the text reported by
+// pg_stat_statements or a slow query log, normalise to the same string.
+func Normalize(sql string, dialect Dialect) string {
+	var (
+		parts  []string
+		tokens []token
+	)
+	sql = replacePlaceholders(sql, dialect, func(string) string { return placeholderWord })
+	for _, t := range tokenize(sql, dialect) {
+		if !t.significant() {
+			continue
+		}
+
+		part := t.text
+		switch t.kind {
+		case tokenString, tokenDollar, tokenNumber, tokenParam:
+			part = "?"
+		case tokenWord:
+			part = strings.ToLower(t.text)
+			if t.text == placeholderWord {
+				part = placeholderPart
+			}
+		case tokenQuotedIdent:
+			if name := identName(t); identifierRe.MatchString(name) {
+				part = strings.ToLower(name)
+			}
+		}
+
+		// Fold the sign of a negative literal into the literal.
+		if n := len(tokens); t.kind == tokenNumber && n > 0 && tokens[n-1].text == "-" && (n == 1 || !endsOperand(tokens[n-2])) {
+			parts = parts[:n-1]
+			tokens = tokens[:n-1]
+		}
+
+		parts = append(parts, part)
+		tokens = append(tokens, t)
+	}
+
+	for len(parts) > 0 && parts[len(parts)-1] == ";" {
+		parts = parts[:len(parts)-1]
+	}
+
+	return collapseLists(strings.Join(parts, " "))
+}
+
+// placeholderWord stands for identifier placeholders while a statement is
+// tokenized by Normalize, which writes them as placeholderPart.
+const (
+	placeholderWord = "squaresql_placeholder__"
+	placeholderPart = "{{}}"
+)
+
+// endsOperand reports whether t can end the left operand of a binary minus.
+func endsOperand(t token) bool {
+	switch t.kind {
+	case tokenWord:
+		return !isKeyword(t.text)
+	case tokenQuotedIdent, tokenNumber, tokenString, tokenParam, tokenDollar:
+		return true
+	case tokenPunct:
+		return t.text == ")" || t.text == "]"
+	}
+	return false
+}
+
+var operatorKeywords = map[string]bool{
+	"select": true, "where": true, "and": true, "or": true, "not": true, "in": true,
+	"by": true, "values": true, "set": true, "then": true, "else": true, "when": true,
+	"limit": true, "offset": true, "between": true, "like": true, "is": true,
+	"return": true, "returning": true, "having": true, "on": true, "case": true,
+}
+
+func isKeyword(word string) bool {
+	return operatorKeywords[strings.ToLower(word)]
+}
+
+// collapseLists reduces lists of placeholders, such as IN lists and the rows
+// of a multi-row VALUES clause, to a single element.
+func collapseLists(normalized string) string {
+	for _, r := range []struct{ old, new string }{
+		{"? , ?", "?"},
+		{"( ? ) , ( ? )", "( ? )"},
+	} {
+		for strings.Contains(normalized, r.old) {
+			normalized = strings.Replace(normalized, r.old, r.new, -1)
+		}
+	}
+	return normalized
+}
+
+func fingerprint(normalized string) string {
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Fingerprint returns the fingerprint of a statement, a hash of its
+// normalised form.
+func Fingerprint(sql string, dialect Dialect) string {
+	return fingerprint(Normalize(sql, dialect))
+}
+
+// NameForSQL maps a statement observed at runtime, e.g. in
+// pg_stat_statements or a slow query log, back to the name of the query it
+// was issued from by comparing fingerprints.
+// Identifier placeholders match any single identifier, so a statement
+// rendered for a tenant maps back to its query.
+func (s *SquareSql) NameForSQL(sql string) (string, bool) {
+	root, prefix := s.scope()
+	normalized := Normalize(sql, root.Dialect())
+	sum := fingerprint(normalized)
+
+	root.mu.RLock()
+	index := root.fingerprints
+	root.mu.RUnlock()
+
+	if index == nil {
+		root.mu.Lock()
+		if root.fingerprints == nil {
+			root.fingerprints = root.indexFingerprints()
+		}
+		index = root.fingerprints
+		root.mu.Unlock()
+	}
+
+	for _, name := range index[sum] {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix), true
+		}
+	}
+
+	return root.nameForTemplate(normalized, prefix)
+}
+
+// nameForTemplate returns the first name under prefix, in sorted order, of a
+// query with identifier placeholders whose normalised form matches
+// normalized.
+func (s *SquareSql) nameForTemplate(normalized, prefix string) (string, bool) {
+	parts := strings.Split(normalized, " ")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var names []string
+	for name, set := range s.queries {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		for _, q := range set {
+			if len(q.placeholders) > 0 && matchTemplate(strings.Split(q.Normalized, " "), parts) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+
+	sort.Strings(names)
+	return strings.TrimPrefix(names[0], prefix), true
+}
+
+// matchTemplate reports whether the parts of a normalised statement match
+// those of a normalised template, where placeholders match any identifier.
+func matchTemplate(template, parts []string) bool {
+	if len(template) != len(parts) {
+		return false
+	}
+	for i, part := range template {
+		if part != parts[i] && (part != placeholderPart || !identifierRe.MatchString(parts[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexFingerprints maps the fingerprint of every query variant to the
+// sorted names sharing it. It must be called with s.mu held.
+func (s *SquareSql) indexFingerprints() map[string][]string {
+	index := make(map[string][]string)
+	for name, set := range s.queries {
+		seen := make(map[string]bool)
+		for _, q := range set {
+			if !seen[q.Fingerprint] {
+				seen[q.Fingerprint] = true
+				index[q.Fingerprint] = append(index[q.Fingerprint], name)
+			}
+		}
+	}
+	for _, names := range index {
+		sort.Strings(names)
+	}
+	return index
+}
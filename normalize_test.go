@@ -0,0 +1,116 @@
+package squaresql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		sql     string
+		want    string
+	}{
+		{
+			name: "literals and case",
+			sql:  "SELECT * FROM Products WHERE name = 'x' AND price > 10.5",
+			want: "select * from products where name = ? and price > ?",
+		},
+		{
+			name: "whitespace and comments",
+			sql:  "-- Finds a user\nSELECT id,\n\tname /* the display name */\nFROM users WHERE id = ?;",
+			want: "select id , name from users where id = ?",
+		},
+		{
+			name:    "postgres parameters and casts",
+			dialect: Postgres,
+			sql:     `SELECT "Id" FROM users WHERE id = $1 AND created_at > $2::timestamptz`,
+			want:    `select id from users where id = ? and created_at > ? :: timestamptz`,
+		},
+		{
+			name:    "quoted identifiers",
+			dialect: MySQL,
+			sql:     "SELECT `Name`, `first name` FROM `Users`",
+			want:    "select name , `first name` from users",
+		},
+		{
+			name:    "identifier placeholders",
+			dialect: Postgres,
+			sql:     `SELECT * FROM {{schema}}.orders WHERE note = '{{schema}}'`,
+			want:    "select * from {{}} . orders where note = ?",
+		},
+		{
+			name: "in lists and values rows",
+			sql:  "INSERT INTO t (a, b) VALUES (1, 2), (3, 4); SELECT 1 FROM t WHERE a IN (1, 2, 3)",
+			want: "insert into t ( a , b ) values ( ? ) ; select ? from t where a in ( ? )",
+		},
+		{
+			name: "negative literals",
+			sql:  "SELECT a - 1 FROM t WHERE b = -3 AND c = d - e AND f > (-4)",
+			want: "select a - ? from t where b = ? and c = d - e and f > ( ? )",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Normalize(tt.sql, tt.dialect))
+		})
+	}
+
+	assert.Equal(t,
+		Fingerprint("SELECT * FROM users WHERE id = ?", Generic),
+		Fingerprint("select *\n  from USERS where id = 42", Generic))
+	assert.NotEqual(t,
+		Fingerprint("SELECT * FROM users WHERE id = ?", Generic),
+		Fingerprint("SELECT * FROM users WHERE email = ?", Generic))
+}
+
+func TestNameForSQL(t *testing.T) {
+	square, err := LoadFromString(`
+	-- name: find-user
+	SELECT * FROM users WHERE id = ?
+	-- name: list-products
+	-- dialect: postgres
+	SELECT * FROM products WHERE price < $1 ORDER BY name
+	-- name: list-products
+	SELECT * FROM products WHERE price < ? ORDER BY name
+	`, WithNamespace("shop"), WithDialect(Postgres))
+	assert.NoError(t, err)
+
+	name, ok := square.NameForSQL("select * from users where id = $1")
+	assert.True(t, ok)
+	assert.Equal(t, "shop.find-user", name)
+
+	name, ok = square.Sub("shop").NameForSQL("SELECT * FROM products WHERE price < 100 ORDER BY name")
+	assert.True(t, ok)
+	assert.Equal(t, "list-products", name)
+
+	_, ok = square.Sub("billing").NameForSQL("SELECT * FROM users WHERE id = 1")
+	assert.False(t, ok)
+
+	_, ok = square.NameForSQL("SELECT * FROM orders")
+	assert.False(t, ok)
+
+	square.Add("shop.list-orders", "SELECT * FROM orders")
+	name, ok = square.NameForSQL("SELECT * FROM orders")
+	assert.True(t, ok)
+	assert.Equal(t, "shop.list-orders", name)
+
+	square.Remove("shop.list-orders")
+	_, ok = square.NameForSQL("SELECT * FROM orders")
+	assert.False(t, ok)
+
+	square.Add("shop.tenant-orders", "SELECT * FROM {{schema}}.orders WHERE id = $1")
+	name, ok = square.NameForSQL(`SELECT * FROM "tenant_a".orders WHERE id = $1`)
+	assert.True(t, ok)
+	assert.Equal(t, "shop.tenant-orders", name)
+
+	name, ok = square.NameForSQL("SELECT * FROM tenant_b.orders WHERE id = 7")
+	assert.True(t, ok)
+	assert.Equal(t, "shop.tenant-orders", name)
+
+	_, ok = square.NameForSQL(`SELECT * FROM "tenant_a".orders WHERE note = $1`)
+	assert.False(t, ok)
+}
@@ -0,0 +1,287 @@
+package squaresql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrStaleVersion is returned by ExecOptimistic when no row is at the
+// expected version any more, because another writer updated it first or it
+// was deleted.
+var ErrStaleVersion = errors.New("squaresql: stale version")
+
+// setEnds and whereEnds are the keywords ending the SET list and the WHERE
+// clause of an UPDATE statement.
+var (
+	setEnds   = map[string]bool{"where": true, "from": true, "output": true, "returning": true, "order": true, "limit": true}
+	whereEnds = map[string]bool{"returning": true, "order": true, "limit": true}
+)
+
+// optimisticQuery rewrites the UPDATE statement query so that it increments
+// column and only updates rows whose column still equals a bind parameter
+// following the query's own.
+func optimisticQuery(query, column string, dialect Dialect) (string, error) {
+	tokens := tokenize(query, dialect)
+	for len(tokens) > 0 && (!tokens[len(tokens)-1].significant() || tokens[len(tokens)-1].text == ";") {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	first := -1
+	set, setEnd, where, whereEnd := -1, -1, -1, -1
+	depth := 0
+	for i, t := range tokens {
+		if !t.significant() {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth > 0 || t.kind != tokenWord:
+		case set < 0:
+			if t.is("set") {
+				set = i
+			}
+		case setEnd < 0:
+			if setEnds[strings.ToLower(t.text)] {
+				setEnd = i
+			}
+			if t.is("where") {
+				where = i
+			}
+			if whereEnds[strings.ToLower(t.text)] {
+				whereEnd = i
+			}
+		case whereEnd < 0:
+			if t.is("where") && where < 0 {
+				where = i
+			} else if whereEnds[strings.ToLower(t.text)] {
+				whereEnd = i
+			}
+		}
+	}
+	if first < 0 || !tokens[first].is("update") {
+		return "", fmt.Errorf("not an UPDATE statement")
+	}
+	if set < 0 {
+		return "", fmt.Errorf("no SET clause")
+	}
+	if setEnd < 0 {
+		setEnd = len(tokens)
+	}
+	if whereEnd < 0 {
+		whereEnd = len(tokens)
+	}
+
+	for i := set + 1; i < setEnd; i++ {
+		if isIdent(tokens[i]) && strings.EqualFold(identName(tokens[i]), column) {
+			for j := i + 1; j < setEnd; j++ {
+				if tokens[j].significant() {
+					if tokens[j].text == "=" {
+						return "", fmt.Errorf("the statement already sets %s", column)
+					}
+					break
+				}
+			}
+		}
+	}
+
+	// after returns the index following the last significant token before i,
+	// where text is inserted without splitting it from what it belongs to.
+	after := func(i int) int {
+		for i > 0 && !tokens[i-1].significant() {
+			i--
+		}
+		return i
+	}
+	param, err := versionParam(tokens, after(whereEnd), dialect)
+	if err != nil {
+		return "", err
+	}
+
+	inserts := map[int]string{after(setEnd): fmt.Sprintf(", %s = %s + 1", column, column)}
+	if where >= 0 {
+		start := where + 1
+		for start < whereEnd && !tokens[start].significant() {
+			start++
+		}
+		inserts[start] = "("
+		inserts[after(whereEnd)] += fmt.Sprintf(") AND %s = %s", column, param)
+	} else {
+		inserts[after(whereEnd)] += fmt.Sprintf(" WHERE %s = %s", column, param)
+	}
+
+	var b strings.Builder
+	for i, t := range tokens {
+		b.WriteString(inserts[i])
+		b.WriteString(t.text)
+	}
+	b.WriteString(inserts[len(tokens)])
+	return b.String(), nil
+}
+
+// versionParam returns the bind parameter for the version, numbered after
+// the parameters of tokens if they are numbered. Positional parameters must
+// all precede at, where the version parameter is inserted.
+func versionParam(tokens []token, at int, dialect Dialect) (string, error) {
+	prefix, next := "?", 0
+	switch dialect {
+	case Postgres:
+		prefix, next = "$", 1
+	case SQLServer:
+		prefix, next = "@p", 1
+	}
+
+	for i, t := range tokens {
+		if t.kind != tokenParam {
+			continue
+		}
+		if t.text == "?" {
+			if i >= at {
+				return "", fmt.Errorf("positional parameter after the WHERE clause")
+			}
+			prefix, next = "?", 0
+			continue
+		}
+		p := t.text[:1]
+		if strings.HasPrefix(t.text, "@p") {
+			p = "@p"
+		}
+		n, err := strconv.Atoi(t.text[len(p):])
+		if err != nil {
+			return "", fmt.Errorf("named parameter %s is not supported", t.text)
+		}
+		if n+1 > next || p != prefix {
+			prefix, next = p, n+1
+		}
+	}
+
+	if next == 0 {
+		return "?", nil
+	}
+	return prefix + strconv.Itoa(next), nil
+}
+
+// optimistic returns the statement run for the named query annotated with
+//
+//	-- optimistic: version
+//
+// where version is the column holding the version of a row.
+func (s *SquareSql) optimistic(ctx context.Context, name string) (query, column string, err error) {
+	q, err := s.lookup(name)
+	if err != nil {
+		return "", "", err
+	}
+	column = q.Annotation("optimistic")
+	if len(column) == 0 {
+		return "", "", fmt.Errorf("squaresql: '%s' has no optimistic annotation", name)
+	}
+	if !identifierRe.MatchString(column) {
+		return "", "", fmt.Errorf("squaresql: invalid optimistic annotation %q on '%s'", column, name)
+	}
+
+	query, err = s.render(ctx, q)
+	if err != nil {
+		return "", "", err
+	}
+	query, err = optimisticQuery(query, column, s.Dialect())
+	if err != nil {
+		return "", "", fmt.Errorf("squaresql: versioning '%s': %v", name, err)
+	}
+	return query, column, nil
+}
+
+// ExecOptimistic runs the named UPDATE for the row at version and returns
+// its new version. The statement is extended to increment the version column
+// named by its optimistic annotation and to only match rows still at
+// version, which is bound after args:
+//
+//	-- name: rename-user
+//	-- optimistic: version
+//	UPDATE users SET name = ? WHERE id = ?
+//
+// is run as
+//
+//	UPDATE users SET name = ?, version = version + 1 WHERE (id = ?) AND version = ?
+//
+// When no row matched, the error wraps ErrStaleVersion.
+func (s *SquareSql) ExecOptimistic(ctx context.Context, db ExecerContext, name string, version int64, args ...interface{}) (int64, error) {
+	query, _, err := s.optimistic(ctx, name)
+	if err != nil {
+		return version, err
+	}
+	return s.execVersion(ctx, db, name, query, version, args)
+}
+
+// ExecOptimisticStruct is ExecOptimistic reading the version from the field
+// of the struct v points to that matches the version column, as Cursor
+// matches columns to fields, and writing the new version back to it.
+func (s *SquareSql) ExecOptimisticStruct(ctx context.Context, db ExecerContext, name string, v interface{}, args ...interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || !isStruct(rv.Elem().Type()) {
+		return fmt.Errorf("squaresql: %T is not a pointer to a struct", v)
+	}
+	query, column, err := s.optimistic(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	paths, err := fieldPaths(rv.Elem().Type(), []string{column})
+	if err != nil {
+		return err
+	}
+	field := fieldByIndex(rv.Elem(), paths[0])
+
+	var version int64
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		version = field.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		version = int64(field.Uint())
+	default:
+		return fmt.Errorf("squaresql: version field of %s is a %s, not an integer", rv.Elem().Type(), field.Type())
+	}
+
+	version, err = s.execVersion(ctx, db, name, query, version, args)
+	if err != nil {
+		return err
+	}
+	if field.Kind() >= reflect.Uint && field.Kind() <= reflect.Uint64 {
+		field.SetUint(uint64(version))
+	} else {
+		field.SetInt(version)
+	}
+	return nil
+}
+
+func (s *SquareSql) execVersion(ctx context.Context, db ExecerContext, name, query string, version int64, args []interface{}) (int64, error) {
+	args = append(args[:len(args):len(args)], version)
+	err := s.run(ctx, db, name, func(string) error {
+		res, err := db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return fmt.Errorf("%w: '%s' at version %d", ErrStaleVersion, name, version)
+		}
+		return nil
+	})
+	if err != nil {
+		return version, err
+	}
+	s.invalidateTagsOf(name)
+
+	return version + 1, nil
+}
@@ -0,0 +1,146 @@
+package squaresql_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecOptimistic(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		dialect squaresql.Dialect
+		query   string
+		want    string
+		err     string
+	}{
+		{
+			name:  "where clause",
+			query: "UPDATE users SET name = ? WHERE id = ? OR email = ?;",
+			want:  "UPDATE users SET name = ?, version = version + 1 WHERE (id = ? OR email = ?) AND version = ?",
+		},
+		{
+			name:  "no where clause",
+			query: "UPDATE settings SET theme = ?",
+			want:  "UPDATE settings SET theme = ?, version = version + 1 WHERE version = ?",
+		},
+		{
+			name:    "numbered parameters and returning",
+			dialect: squaresql.Postgres,
+			query:   "UPDATE users SET name = $2 WHERE id = $1 RETURNING name",
+			want:    "UPDATE users SET name = $2, version = version + 1 WHERE (id = $1) AND version = $3 RETURNING name",
+		},
+		{
+			name:    "from clause",
+			dialect: squaresql.Postgres,
+			query:   "UPDATE users u SET name = t.name FROM tmp t WHERE u.id = t.id",
+			want:    "UPDATE users u SET name = t.name, version = version + 1 FROM tmp t WHERE (u.id = t.id) AND version = $1",
+		},
+		{
+			name:    "sqlserver parameters",
+			dialect: squaresql.SQLServer,
+			query:   "UPDATE users SET name = @p1 WHERE id = @p2",
+			want:    "UPDATE users SET name = @p1, version = version + 1 WHERE (id = @p2) AND version = @p3",
+		},
+		{
+			name:  "not an update",
+			query: "DELETE FROM users WHERE id = ?",
+			err:   "squaresql: versioning 'update': not an UPDATE statement",
+		},
+		{
+			name:  "already versioned",
+			query: "UPDATE users SET name = ?, version = ? WHERE id = ?",
+			err:   "squaresql: versioning 'update': the statement already sets version",
+		},
+		{
+			name:    "positional parameter after where",
+			dialect: squaresql.MySQL,
+			query:   "UPDATE users SET name = ? WHERE id > ? ORDER BY id LIMIT ?",
+			err:     "squaresql: versioning 'update': positional parameter after the WHERE clause",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			square, err := squaresql.LoadFromString(`
+			-- name: update
+			-- optimistic: version
+			`+tt.query, squaresql.WithDialect(tt.dialect))
+			assert.NoError(t, err)
+
+			db, mock := squaresqltest.New(t, square)
+			if len(tt.err) == 0 {
+				mock.ExpectExecSQL(tt.want).WithArgs("ann", 1, int64(3)).WillReturnResult(0, 1)
+			}
+
+			version, err := square.ExecOptimistic(ctx, db, "update", 3, "ann", 1)
+			if len(tt.err) > 0 {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, int64(4), version)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	square, err := squaresql.LoadFromString(`
+	-- name: rename-user
+	-- optimistic: version
+	UPDATE users SET name = ? WHERE id = ?
+
+	-- name: plain
+	UPDATE users SET name = ?
+	`)
+	assert.NoError(t, err)
+	query := "UPDATE users SET name = ?, version = version + 1 WHERE (id = ?) AND version = ?"
+
+	t.Run("stale version", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectExecSQL(query).WithArgs("ann", 1, int64(3)).WillReturnResult(0, 0)
+
+		version, err := square.ExecOptimistic(ctx, db, "rename-user", 3, "ann", 1)
+		assert.True(t, errors.Is(err, squaresql.ErrStaleVersion))
+		assert.EqualError(t, err, "squaresql: stale version: 'rename-user' at version 3")
+		assert.Equal(t, int64(3), version)
+	})
+
+	t.Run("struct", func(t *testing.T) {
+		type user struct {
+			ID      int
+			Name    string
+			Version uint32 `db:"version"`
+		}
+		u := &user{ID: 1, Name: "ann", Version: 3}
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectExecSQL(query).WithArgs("ann", 1, int64(3)).WillReturnResult(0, 1)
+		mock.ExpectExecSQL(query).WithArgs("ann", 1, int64(4)).WillReturnResult(0, 0)
+
+		assert.NoError(t, square.ExecOptimisticStruct(ctx, db, "rename-user", u, u.Name, u.ID))
+		assert.Equal(t, uint32(4), u.Version)
+
+		err := square.ExecOptimisticStruct(ctx, db, "rename-user", u, u.Name, u.ID)
+		assert.True(t, errors.Is(err, squaresql.ErrStaleVersion))
+		assert.Equal(t, uint32(4), u.Version)
+
+		err = square.ExecOptimisticStruct(ctx, db, "rename-user", *u, u.Name, u.ID)
+		assert.EqualError(t, err, "squaresql: squaresql_test.user is not a pointer to a struct")
+
+		var missing struct{ Name string }
+		err = square.ExecOptimisticStruct(ctx, db, "rename-user", &missing, "ann", 1)
+		assert.EqualError(t, err, `squaresql: column "version" has no matching field in struct { Name string }`)
+	})
+
+	t.Run("no annotation", func(t *testing.T) {
+		db, _ := squaresqltest.New(t, square)
+		_, err := square.ExecOptimistic(ctx, db, "plain", 1, "ann")
+		assert.EqualError(t, err, "squaresql: 'plain' has no optimistic annotation")
+	})
+}
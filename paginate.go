@@ -0,0 +1,355 @@
+package squaresql
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page is a page of rows returned by Paginate.
+type Page struct {
+	ResultSet
+	// Next is the cursor of the following page, or empty on the last page.
+	Next string
+}
+
+// sortKey is a column of the sort order declared by a paginate annotation.
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+var identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseSortKeys parses a paginate annotation such as `created_at desc, id`.
+func parseSortKeys(annotation string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, part := range strings.Split(annotation, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 || len(fields) > 2 || !identifierRe.MatchString(fields[0]) {
+			return nil, fmt.Errorf("invalid sort key %q", strings.TrimSpace(part))
+		}
+
+		key := sortKey{column: fields[0]}
+		if len(fields) == 2 {
+			switch strings.ToLower(fields[1]) {
+			case "asc":
+			case "desc":
+				key.desc = true
+			default:
+				return nil, fmt.Errorf("invalid sort direction %q", fields[1])
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// pageCursor is the decoded form of the cursor of a page.
+type pageCursor struct {
+	Query string        `json:"q"`
+	Keys  string        `json:"k"`
+	After []cursorValue `json:"a"`
+}
+
+// cursorValue is a sort key value that survives a round trip through JSON
+// with its type intact.
+type cursorValue struct {
+	Int    *int64   `json:"i,omitempty"`
+	Float  *float64 `json:"f,omitempty"`
+	Bool   *bool    `json:"b,omitempty"`
+	String *string  `json:"s,omitempty"`
+	Bytes  *[]byte  `json:"x,omitempty"`
+	Time   *string  `json:"t,omitempty"`
+}
+
+func newCursorValue(v interface{}) (cursorValue, error) {
+	var c cursorValue
+	switch x := v.(type) {
+	case int64:
+		c.Int = &x
+	case float64:
+		c.Float = &x
+	case bool:
+		c.Bool = &x
+	case string:
+		c.String = &x
+	case []byte:
+		x = append([]byte{}, x...)
+		c.Bytes = &x
+	case time.Time:
+		s := x.Format(time.RFC3339Nano)
+		c.Time = &s
+	case nil:
+		return c, fmt.Errorf("sort keys must not be NULL")
+	default:
+		return c, fmt.Errorf("unsupported sort key type %T", v)
+	}
+	return c, nil
+}
+
+func (c cursorValue) value() (interface{}, error) {
+	switch {
+	case c.Int != nil:
+		return *c.Int, nil
+	case c.Float != nil:
+		return *c.Float, nil
+	case c.Bool != nil:
+		return *c.Bool, nil
+	case c.String != nil:
+		return *c.String, nil
+	case c.Bytes != nil:
+		return *c.Bytes, nil
+	case c.Time != nil:
+		return time.Parse(time.RFC3339Nano, *c.Time)
+	}
+	return nil, fmt.Errorf("empty value")
+}
+
+// SetCursorKey sets the key page cursors are signed with, so that Paginate
+// rejects cursors that were not issued by it. Cursors are not signed when
+// key is empty.
+func (s *SquareSql) SetCursorKey(key []byte) {
+	root, _ := s.scope()
+	root.mu.Lock()
+	root.cursorKey = append([]byte(nil), key...)
+	root.mu.Unlock()
+}
+
+func (s *SquareSql) signCursor(payload string) string {
+	root, _ := s.scope()
+	root.mu.RLock()
+	key := root.cursorKey
+	root.mu.RUnlock()
+
+	if len(key) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *SquareSql) encodeCursor(c *pageCursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(data)
+	if sig := s.signCursor(token); len(sig) > 0 {
+		token += "." + sig
+	}
+	return token, nil
+}
+
+func (s *SquareSql) decodeCursor(token string) (*pageCursor, error) {
+	payload, sig := token, ""
+	if i := strings.IndexByte(token, '.'); i >= 0 {
+		payload, sig = token[:i], token[i+1:]
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signCursor(payload))) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	c := &pageCursor{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Paginate returns a page of at most size rows of the named query, which
+// must be annotated with the columns its rows are ordered by, such as
+// `-- paginate: created_at desc, id desc`. The columns must be returned by
+// the query, never be NULL and identify a row uniquely. The query is wrapped
+// with the keyset predicate, ORDER BY and LIMIT clauses selecting the rows
+// following cursor, which is empty for the first page and the Next cursor of
+// the previous page otherwise.
+func (s *SquareSql) Paginate(ctx context.Context, db QueryerContext, name string, args []interface{}, size int, cursor string) (*Page, error) {
+	q, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("squaresql: invalid page size %d", size)
+	}
+
+	annotation := q.Annotation("paginate")
+	if len(annotation) == 0 {
+		return nil, fmt.Errorf("squaresql: '%s' has no paginate annotation", q.Name)
+	}
+	keys, err := parseSortKeys(annotation)
+	if err != nil {
+		return nil, fmt.Errorf("squaresql: paginate annotation of '%s': %v", q.Name, err)
+	}
+
+	var after []interface{}
+	if len(cursor) > 0 {
+		c, err := s.decodeCursor(cursor)
+		if err != nil {
+			return nil, fmt.Errorf("squaresql: invalid cursor: %v", err)
+		}
+		if c.Query != q.Name || c.Keys != annotation || len(c.After) != len(keys) {
+			return nil, fmt.Errorf("squaresql: cursor was not issued for '%s'", q.Name)
+		}
+		for _, cv := range c.After {
+			v, err := cv.value()
+			if err != nil {
+				return nil, fmt.Errorf("squaresql: invalid cursor: %v", err)
+			}
+			after = append(after, v)
+		}
+	}
+
+	dialect := s.Dialect()
+	page := &Page{}
+	err = s.run(ctx, db, name, func(query string) error {
+		paged, pagedArgs := paginateQuery(query, dialect, keys, args, after, size+1)
+		rows, err := db.QueryContext(ctx, paged, pagedArgs...)
+		if err != nil {
+			return err
+		}
+		rs, err := readResultSet(rows)
+		if err != nil {
+			return err
+		}
+		page.ResultSet = *rs
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(page.Rows) <= size {
+		return page, nil
+	}
+	page.Rows = page.Rows[:size]
+
+	next := &pageCursor{Query: q.Name, Keys: annotation}
+	last := page.Rows[size-1]
+	for _, key := range keys {
+		i := columnIndex(page.Columns, key.column)
+		if i < 0 {
+			return nil, fmt.Errorf("squaresql: sort key %s is not a column of '%s'", key.column, q.Name)
+		}
+		cv, err := newCursorValue(last[i])
+		if err != nil {
+			return nil, fmt.Errorf("squaresql: sort key %s of '%s': %v", key.column, q.Name, err)
+		}
+		next.After = append(next.After, cv)
+	}
+	if page.Next, err = s.encodeCursor(next); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+func columnIndex(columns []string, column string) int {
+	for i, c := range columns {
+		if strings.EqualFold(c, column) {
+			return i
+		}
+	}
+	return -1
+}
+
+// paginateQuery wraps query so that it returns at most limit rows following
+// after in the order of keys. Bind parameters are numbered after those of
+// the query when it uses numbered parameters.
+func paginateQuery(query string, dialect Dialect, keys []sortKey, args, after []interface{}, limit int) (string, []interface{}) {
+	tokens := tokenize(query, dialect)
+	for len(tokens) > 0 && (!tokens[len(tokens)-1].significant() || tokens[len(tokens)-1].text == ";") {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	// Continue the numbering of the query's own parameters, if any.
+	prefix, next := "?", 0
+	switch dialect {
+	case Postgres:
+		prefix, next = "$", 1
+	case SQLServer:
+		prefix, next = "@p", 1
+	}
+	for _, t := range tokens {
+		if t.kind != tokenParam {
+			continue
+		}
+		if t.text == "?" {
+			prefix, next = "?", 0
+			break
+		}
+		p := t.text[:1]
+		if strings.HasPrefix(t.text, "@p") {
+			p = "@p"
+		}
+		if n, err := strconv.Atoi(t.text[len(p):]); err == nil {
+			prefix = p
+			if n+1 > next {
+				next = n + 1
+			}
+		}
+	}
+
+	pagedArgs := append([]interface{}(nil), args...)
+	param := func(v interface{}) string {
+		pagedArgs = append(pagedArgs, v)
+		if next == 0 {
+			return "?"
+		}
+		next++
+		return prefix + strconv.Itoa(next-1)
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT * FROM (")
+	for _, t := range tokens {
+		b.WriteString(t.text)
+	}
+	b.WriteString(") AS page")
+
+	if len(after) > 0 {
+		var disjuncts []string
+		for i, key := range keys {
+			var conjuncts []string
+			for j, prev := range keys[:i] {
+				conjuncts = append(conjuncts, prev.column+" = "+param(after[j]))
+			}
+			op := " > "
+			if key.desc {
+				op = " < "
+			}
+			conjuncts = append(conjuncts, key.column+op+param(after[i]))
+			disjuncts = append(disjuncts, "("+strings.Join(conjuncts, " AND ")+")")
+		}
+		b.WriteString(" WHERE " + strings.Join(disjuncts, " OR "))
+	}
+
+	orders := make([]string, len(keys))
+	for i, key := range keys {
+		orders[i] = key.column + " ASC"
+		if key.desc {
+			orders[i] = key.column + " DESC"
+		}
+	}
+	// SQL Server has no LIMIT clause.
+	if dialect == SQLServer {
+		fmt.Fprintf(&b, " ORDER BY %s OFFSET 0 ROWS FETCH NEXT %d ROWS ONLY", strings.Join(orders, ", "), limit)
+	} else {
+		fmt.Fprintf(&b, " ORDER BY %s LIMIT %d", strings.Join(orders, ", "), limit)
+	}
+
+	return b.String(), pagedArgs
+}
@@ -0,0 +1,136 @@
+package squaresql_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaginate(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: list-posts
+	-- paginate: created_at desc, id desc
+	SELECT id, title, created_at FROM posts WHERE author = ?;
+
+	-- name: list-tags
+	-- paginate: name
+	SELECT name FROM tags WHERE hidden = $1
+
+	-- name: list-all
+	SELECT id FROM posts
+	`)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	day := func(d int) time.Time { return time.Date(2021, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	db, mock := squaresqltest.New(t, square)
+	mock.ExpectQuerySQL("SELECT * FROM (SELECT id, title, created_at FROM posts WHERE author = ?) AS page ORDER BY created_at DESC, id DESC LIMIT 3").
+		WithArgs("alice").
+		WillReturnRows(squaresqltest.NewRows("id", "title", "created_at").
+			AddRow(int64(5), "e", day(3)).
+			AddRow(int64(4), "d", day(2)).
+			AddRow(int64(3), "c", day(2)))
+	mock.ExpectQuerySQL("SELECT * FROM (SELECT id, title, created_at FROM posts WHERE author = ?) AS page WHERE (created_at < ?) OR (created_at = ? AND id < ?) ORDER BY created_at DESC, id DESC LIMIT 3").
+		WithArgs("alice", day(2), day(2), int64(4)).
+		WillReturnRows(squaresqltest.NewRows("id", "title", "created_at").
+			AddRow(int64(3), "c", day(2)))
+
+	page, err := square.Paginate(ctx, db, "list-posts", []interface{}{"alice"}, 2, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, page.Rows, 2)
+	assert.NotEmpty(t, page.Next)
+	next := page.Next
+
+	page, err = square.Paginate(ctx, db, "list-posts", []interface{}{"alice"}, 2, next)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, [][]interface{}{{int64(3), "c", day(2)}}, page.Rows)
+	assert.Empty(t, page.Next)
+
+	t.Run("numbered parameters", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuerySQL("SELECT * FROM (SELECT name FROM tags WHERE hidden = $1) AS page ORDER BY name ASC LIMIT 2").
+			WithArgs(false).
+			WillReturnRows(squaresqltest.NewRows("name").AddRow("go").AddRow("sql"))
+		mock.ExpectQuerySQL("SELECT * FROM (SELECT name FROM tags WHERE hidden = $1) AS page WHERE (name > $2) ORDER BY name ASC LIMIT 2").
+			WithArgs(false, "go").
+			WillReturnRows(squaresqltest.NewRows("name").AddRow("sql"))
+
+		page, err := square.Paginate(ctx, db, "list-tags", []interface{}{false}, 1, "")
+		if assert.NoError(t, err) {
+			_, err = square.Paginate(ctx, db, "list-tags", []interface{}{false}, 1, page.Next)
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("sqlserver", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(`
+		-- name: list-tags
+		-- paginate: name
+		SELECT name FROM tags WHERE hidden = @p1
+		`, squaresql.WithDialect(squaresql.SQLServer))
+		assert.NoError(t, err)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuerySQL("SELECT * FROM (SELECT name FROM tags WHERE hidden = @p1) AS page ORDER BY name ASC OFFSET 0 ROWS FETCH NEXT 2 ROWS ONLY").
+			WithArgs(false).
+			WillReturnRows(squaresqltest.NewRows("name").AddRow("go").AddRow("sql"))
+		mock.ExpectQuerySQL("SELECT * FROM (SELECT name FROM tags WHERE hidden = @p1) AS page WHERE (name > @p2) ORDER BY name ASC OFFSET 0 ROWS FETCH NEXT 2 ROWS ONLY").
+			WithArgs(false, "go").
+			WillReturnRows(squaresqltest.NewRows("name").AddRow("sql"))
+
+		page, err := square.Paginate(ctx, db, "list-tags", []interface{}{false}, 1, "")
+		if assert.NoError(t, err) {
+			_, err = square.Paginate(ctx, db, "list-tags", []interface{}{false}, 1, page.Next)
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("signed cursors", func(t *testing.T) {
+		square.SetCursorKey([]byte("secret"))
+		defer square.SetCursorKey(nil)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuerySQL("SELECT * FROM (SELECT name FROM tags WHERE hidden = $1) AS page ORDER BY name ASC LIMIT 2").
+			WithArgs(false).
+			WillReturnRows(squaresqltest.NewRows("name").AddRow("go").AddRow("sql"))
+
+		page, err := square.Paginate(ctx, db, "list-tags", []interface{}{false}, 1, "")
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = square.Paginate(ctx, db, "list-tags", []interface{}{false}, 1, page.Next+"x")
+		assert.Error(t, err)
+
+		square.SetCursorKey([]byte("rotated"))
+		_, err = square.Paginate(ctx, db, "list-tags", []interface{}{false}, 1, page.Next)
+		assert.Error(t, err)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		db, _ := squaresqltest.New(t, square)
+		for _, tt := range []struct {
+			name   string
+			size   int
+			cursor string
+		}{
+			{"list-all", 10, ""},
+			{"list-posts", 0, ""},
+			{"list-posts", 10, "not a cursor"},
+			// A cursor issued for another query.
+			{"list-tags", 1, next},
+		} {
+			_, err := square.Paginate(ctx, db, tt.name, nil, tt.size, tt.cursor)
+			assert.Error(t, err, tt.name)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package squaresql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query is a named query together with the metadata declared in its header.
+//
+//	-- name: upsert-user
+//	-- dialect: postgres
+//	INSERT INTO users ...
+//
+// Every `-- key: value` comment directly following the name tag is an
+// annotation; the dialect annotation marks the query as a variant that is
+// only used when the SquareSql is configured with that dialect.
+type Query struct {
+	Name        string            `json:"name"`
+	SQL         string            `json:"sql"`
+	Dialect     Dialect           `json:"dialect,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// File and Line locate the name tag the query was declared with.
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+	// Normalized is the canonical form of the query text computed by
+	// Normalize, and Fingerprint a stable hash of it.
+	Normalized  string `json:"normalized,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// generated is the statement run in place of SQL, such as the upsert of
+	// the configured dialect, and err the error generating it.
+	generated string
+	err       error
+	// placeholders are the names of the identifier placeholders of the
+	// statement.
+	placeholders []string
+}
+
+// Annotation returns the value of the annotation key, or an empty string.
+func (q *Query) Annotation(key string) string {
+	return q.Annotations[strings.ToLower(key)]
+}
+
+// flag parses the boolean annotation key. ok is false when the query is not
+// annotated with key.
+func (q *Query) flag(key string) (value, ok bool, err error) {
+	annotation := q.Annotation(key)
+	if len(annotation) == 0 {
+		return false, false, nil
+	}
+	value, err = strconv.ParseBool(annotation)
+	if err != nil {
+		return false, false, fmt.Errorf("squaresql: invalid %s annotation %q on '%s'", key, annotation, q.Name)
+	}
+	return value, true, nil
+}
+
+// Tags returns the comma or space separated values of the tags annotation.
+func (q *Query) Tags() []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(q.Annotation("tags"), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	}) {
+		if tag = strings.Trim(tag, `"'`); len(tag) > 0 {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// derive fills in the fields computed from the query text. Generic queries
+// are tokenized according to fallback.
+func (q *Query) derive(fallback Dialect) {
+	dialect := q.Dialect
+	if dialect == Generic {
+		dialect = fallback
+	}
+	q.generated, q.err = "", nil
+	if len(q.Annotation("upsert")) > 0 {
+		q.generated, q.err = q.upsert(dialect)
+	}
+	q.placeholders = placeholders(q.text(), dialect)
+	q.Normalized = Normalize(q.text(), dialect)
+	q.Fingerprint = fingerprint(q.Normalized)
+}
+
+// text returns the statement run for the query.
+func (q *Query) text() string {
+	if len(q.generated) > 0 {
+		return q.generated
+	}
+	return q.SQL
+}
+
+func (q *Query) clone() *Query {
+	c := *q
+	if q.Annotations != nil {
+		c.Annotations = make(map[string]string, len(q.Annotations))
+		for k, v := range q.Annotations {
+			c.Annotations[k] = v
+		}
+	}
+	return &c
+}
+
+// querySet holds every variant of a named query keyed by dialect. The
+// Generic entry, if any, is the fallback for dialects without a variant.
+type querySet map[Dialect]*Query
+
+func (qs querySet) resolve(dialect Dialect) (*Query, bool) {
+	if q, ok := qs[dialect]; ok {
+		return q, true
+	}
+	q, ok := qs[Generic]
+	return q, ok
+}
+
+func (qs querySet) clone() querySet {
+	c := make(querySet, len(qs))
+	for d, q := range qs {
+		c[d] = q
+	}
+	return c
+}
+
+func genericSets(queries map[string]string, dialect Dialect) map[string]querySet {
+	sets := make(map[string]querySet, len(queries))
+	for name, sql := range queries {
+		q := &Query{Name: name, SQL: sql}
+		q.derive(dialect)
+		sets[name] = querySet{Generic: q}
+	}
+	return sets
+}
@@ -0,0 +1,101 @@
+package squaresql
+
+import (
+	"context"
+	"database/sql"
+)
+
+// ResultSet is a fully read query result. Result sets returned by Fetch may
+// be shared between callers and must not be modified.
+type ResultSet struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+func readResultSet(rows *sql.Rows) (*ResultSet, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &ResultSet{Columns: columns}
+	for rows.Next() {
+		row := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		rs.Rows = append(rs.Rows, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rs, rows.Close()
+}
+
+// Fetch runs the named query and reads all of its rows. Results of queries
+// annotated with `-- cache: <duration>` are served from the cache configured
+// with SetCache while they are fresh, and concurrent identical calls are
+// collapsed into one when enabled with SetSingleflight.
+func (s *SquareSql) Fetch(ctx context.Context, db QueryerContext, name string, args ...interface{}) (*ResultSet, error) {
+	q, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	store, ttl, err := s.cacheFor(q)
+	if err != nil {
+		return nil, err
+	}
+	collapse, err := s.collapses(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var key string
+	if store != nil || collapse {
+		key = s.cacheKey(ctx, q, args)
+	}
+	if store != nil {
+		if rs, ok := store.Get(key); ok {
+			s.recordCacheHit(q.Name)
+			return rs, nil
+		}
+	}
+
+	load := func() (*ResultSet, error) {
+		var rs *ResultSet
+		err := s.run(ctx, db, name, func(query string) error {
+			rows, err := db.QueryContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			rs, err = readResultSet(rows)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if store != nil {
+			store.Set(key, rs, ttl)
+		}
+		return rs, nil
+	}
+	if !collapse {
+		return load()
+	}
+
+	root, _ := s.scope()
+	rs, shared, err := root.flights.do(key, load)
+	if shared {
+		s.recordCollapsed(q.Name)
+	}
+	return rs, err
+}
@@ -0,0 +1,195 @@
+package squaresql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures how calls of queries annotated with
+// `-- idempotent: true` or `-- readonly: true` are retried after transient
+// errors. Calls are never retried past the deadline of their context.
+// QueryRow reports errors only when the row is scanned, so its calls are not
+// retried. Neither are calls on a transaction: a deadlock or serialization
+// failure aborts the whole transaction, which only the caller can restart.
+// ExecScriptTx and ExecBatch begin their transaction on every attempt, so
+// they are retried as a whole.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles after every
+	// attempt up to MaxDelay, if set, and is jittered by up to half.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Classifier reports whether an error is transient. DefaultClassifier is
+	// used when it is nil.
+	Classifier Classifier
+}
+
+// Classifier reports whether err is transient, i.e. whether the call that
+// failed with it may succeed when retried.
+type Classifier func(err error) bool
+
+// ClassifyAny returns a Classifier reporting errors any of classifiers deems
+// transient.
+func ClassifyAny(classifiers ...Classifier) Classifier {
+	return func(err error) bool {
+		for _, classify := range classifiers {
+			if classify(err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// DefaultClassifier recognises broken connections, and deadlocks and
+// serialization failures reported by PostgreSQL and MySQL drivers.
+var DefaultClassifier = ClassifyAny(IsConnectionError, IsSQLStateRetryable, IsMySQLRetryable)
+
+// IsConnectionError reports whether err signals a broken connection.
+func IsConnectionError(err error) bool {
+	for _, target := range []error{
+		driver.ErrBadConn,
+		io.ErrUnexpectedEOF,
+		syscall.ECONNRESET,
+		syscall.ECONNREFUSED,
+		syscall.ECONNABORTED,
+		syscall.EPIPE,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSQLStateRetryable reports whether err carries a SQLSTATE, as the errors
+// of the lib/pq and pgx drivers do, denoting a serialization failure
+// (40001), a deadlock (40P01) or a connection exception (class 08).
+func IsSQLStateRetryable(err error) bool {
+	var state interface{ SQLState() string }
+	if !errors.As(err, &state) {
+		return false
+	}
+
+	code := state.SQLState()
+	return code == "40001" || code == "40P01" || strings.HasPrefix(code, "08")
+}
+
+var mysqlErrorRe = regexp.MustCompile(`^Error (\d+)`)
+
+// IsMySQLRetryable reports whether err is a MySQL error, as formatted by the
+// go-sql-driver/mysql driver, for a deadlock (1213), a lock wait timeout
+// (1205) or a lost connection (2006, 2013).
+func IsMySQLRetryable(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		matches := mysqlErrorRe.FindStringSubmatch(err.Error())
+		if matches == nil {
+			continue
+		}
+		switch code, _ := strconv.Atoi(matches[1]); code {
+		case 1205, 1213, 2006, 2013:
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// SetRetryPolicy sets the policy used to retry idempotent and read-only
+// queries. A nil policy disables retries.
+func (s *SquareSql) SetRetryPolicy(policy *RetryPolicy) {
+	root, _ := s.scope()
+	root.retryMu.Lock()
+	defer root.retryMu.Unlock()
+
+	if policy == nil {
+		root.retry = nil
+		return
+	}
+	p := *policy
+	root.retry = &p
+}
+
+// inTx reports whether db is a transaction, such as a *sql.Tx.
+func inTx(db interface{}) bool {
+	_, ok := db.(interface {
+		Commit() error
+		Rollback() error
+	})
+	return ok
+}
+
+// retryPolicyFor returns the policy calls of q are retried with, or nil.
+func (s *SquareSql) retryPolicyFor(q *Query) (*RetryPolicy, error) {
+	idempotent, _, err := q.flag("idempotent")
+	if err != nil {
+		return nil, err
+	}
+	readonly, _, err := q.flag("readonly")
+	if err != nil {
+		return nil, err
+	}
+	if !idempotent && !readonly {
+		return nil, nil
+	}
+
+	root, _ := s.scope()
+	root.retryMu.Lock()
+	defer root.retryMu.Unlock()
+
+	return root.retry, nil
+}
+
+func (p *RetryPolicy) transient(err error) bool {
+	if p.Classifier == nil {
+		return DefaultClassifier(err)
+	}
+	return p.Classifier(err)
+}
+
+// delay returns the jittered delay before the retry following attempt.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + rand.Int63n(half+1))
+	}
+	return d
+}
+
+// retry reports whether a call failing with err on attempt should be
+// retried, waiting for the backoff delay first. It gives up rather than wait
+// past the deadline of ctx.
+func (p *RetryPolicy) retry(ctx context.Context, attempt int, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts || !p.transient(err) {
+		return false
+	}
+
+	d := p.delay(attempt)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return false
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,160 @@
+package squaresql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+type pgError struct{ code string }
+
+func (e *pgError) Error() string    { return "pq: error " + e.code }
+func (e *pgError) SQLState() string { return e.code }
+
+func TestClassifiers(t *testing.T) {
+	for _, tt := range []struct {
+		err       error
+		transient bool
+	}{
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("query: %w", syscall.ECONNRESET), true},
+		{&pgError{"40001"}, true},
+		{&pgError{"40P01"}, true},
+		{&pgError{"08006"}, true},
+		{&pgError{"23505"}, false},
+		{errors.New("Error 1213 (40001): Deadlock found when trying to get lock"), true},
+		{fmt.Errorf("wrapped: %w", errors.New("Error 2006: MySQL server has gone away")), true},
+		{errors.New("Error 1062: Duplicate entry"), false},
+		{errors.New("syntax error"), false},
+	} {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			assert.Equal(t, tt.transient, squaresql.DefaultClassifier(tt.err))
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: get-user
+	-- readonly: true
+	SELECT name FROM users WHERE id = ?
+
+	-- name: touch-user
+	-- idempotent: true
+	UPDATE users SET seen = true WHERE id = ?
+
+	-- name: insert-user
+	INSERT INTO users (name) VALUES (?)
+
+	-- name: broken
+	-- idempotent: maybe
+	SELECT 1
+	`)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	serialization := &pgError{"40001"}
+	square.SetRetryPolicy(&squaresql.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+	t.Run("retries read-only queries", func(t *testing.T) {
+		square.ResetStats()
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("get-user").WithArgs(1).WillReturnError(serialization)
+		mock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(squaresqltest.NewRows("name").AddRow("alice"))
+
+		rows, err := square.QueryContext(ctx, db, "get-user", 1)
+		if assert.NoError(t, err) {
+			assert.NoError(t, rows.Close())
+		}
+		stats := square.Stats()["get-user"]
+		assert.Equal(t, int64(1), stats.Calls)
+		assert.Equal(t, int64(1), stats.Retries)
+		assert.Equal(t, int64(0), stats.Errors)
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		for i := 0; i < 3; i++ {
+			mock.ExpectExec("touch-user").WithArgs(1).WillReturnError(serialization)
+		}
+
+		_, err := square.ExecContext(ctx, db, "touch-user", 1)
+		assert.Equal(t, serialization, err)
+	})
+
+	t.Run("does not retry other queries", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectExec("insert-user").WithArgs("bob").WillReturnError(serialization)
+
+		_, err := square.ExecContext(ctx, db, "insert-user", "bob")
+		assert.Equal(t, serialization, err)
+	})
+
+	t.Run("does not retry inside a transaction", func(t *testing.T) {
+		square.ResetStats()
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectBegin()
+		mock.ExpectExec("touch-user").WithArgs(1).WillReturnError(serialization)
+		mock.ExpectRollback()
+
+		tx, err := db.BeginTx(ctx, nil)
+		assert.NoError(t, err)
+		_, err = square.ExecContext(ctx, tx, "touch-user", 1)
+		assert.Equal(t, serialization, err)
+		assert.NoError(t, tx.Rollback())
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Equal(t, int64(0), square.Stats()["touch-user"].Retries)
+	})
+
+	t.Run("does not retry permanent errors", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		permanent := &pgError{"23505"}
+		mock.ExpectExec("touch-user").WithArgs(1).WillReturnError(permanent)
+
+		_, err := square.ExecContext(ctx, db, "touch-user", 1)
+		assert.Equal(t, permanent, err)
+	})
+
+	t.Run("respects the deadline", func(t *testing.T) {
+		square.SetRetryPolicy(&squaresql.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour})
+		defer square.SetRetryPolicy(&squaresql.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectExec("touch-user").WithArgs(1).WillReturnError(serialization)
+
+		_, err := square.ExecContext(ctx, db, "touch-user", 1)
+		assert.Equal(t, serialization, err)
+	})
+
+	t.Run("custom classifier", func(t *testing.T) {
+		flaky := errors.New("flaky")
+		square.SetRetryPolicy(&squaresql.RetryPolicy{
+			MaxAttempts: 2,
+			Classifier:  func(err error) bool { return err == flaky },
+		})
+		defer square.SetRetryPolicy(nil)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectExec("touch-user").WithArgs(1).WillReturnError(flaky)
+		mock.ExpectExec("touch-user").WithArgs(1).WillReturnResult(0, 1)
+
+		_, err := square.ExecContext(ctx, db, "touch-user", 1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalid annotation", func(t *testing.T) {
+		db, _ := squaresqltest.New(t, square)
+		_, err := square.ExecContext(ctx, db, "broken")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,127 @@
+package squaresql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ReturningDB is an interface used by ExecReturning.
+type ReturningDB interface {
+	QueryerContext
+	ExecerContext
+}
+
+// ExecReturning runs the named INSERT or upsert and returns the id generated
+// for the row it wrote. The id column, "id" unless the query is annotated
+// with `-- returning: <column>`, is read through a RETURNING clause on
+// PostgreSQL and SQLite, an OUTPUT clause on SQL Server and LastInsertId on
+// MySQL and without a dialect. Queries that already return a column are run
+// as written. When nothing is written, as when an upsert with nothing to
+// update meets an existing row on PostgreSQL or SQLite, the error is
+// sql.ErrNoRows.
+func (s *SquareSql) ExecReturning(ctx context.Context, db ReturningDB, name string, args ...interface{}) (int64, error) {
+	q, err := s.lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	column := q.Annotation("returning")
+	if len(column) == 0 {
+		column = "id"
+	}
+	query, err := s.render(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	query, returns, err := returningQuery(query, column, s.Dialect())
+	if err != nil {
+		return 0, fmt.Errorf("squaresql: returning %s from '%s': %v", column, name, err)
+	}
+
+	var id int64
+	err = s.run(ctx, db, name, func(string) error {
+		if !returns {
+			res, err := db.ExecContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			id, err = res.LastInsertId()
+			return err
+		}
+
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			return sql.ErrNoRows
+		}
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		return rows.Close()
+	})
+	if err == nil {
+		s.invalidateTagsOf(name)
+	}
+
+	return id, err
+}
+
+// returningQuery adds the clause returning column to query for dialect.
+// returns is false when the id has to be read through LastInsertId instead.
+func returningQuery(query, column string, dialect Dialect) (string, bool, error) {
+	tokens := tokenize(query, dialect)
+
+	// statement is query without its terminating semicolon and anything
+	// following it.
+	var statement strings.Builder
+	first, last, depth := -1, -1, 0
+	for i, t := range tokens {
+		if !t.significant() {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth == 0 && (t.is("returning") || t.is("output")):
+			return query, true, nil
+		}
+		if t.text != ";" {
+			last = i
+		}
+	}
+	if first < 0 {
+		return "", false, fmt.Errorf("empty query")
+	}
+	for _, t := range tokens[:last+1] {
+		statement.WriteString(t.text)
+	}
+
+	switch dialect {
+	case Postgres, SQLite:
+		return statement.String() + " RETURNING " + column, true, nil
+
+	case SQLServer:
+		if tokens[first].is("merge") {
+			return statement.String() + " OUTPUT INSERTED." + column + ";", true, nil
+		}
+		stmt, err := parseInsert(statement.String(), dialect)
+		if err != nil {
+			return "", false, err
+		}
+		return stmt.head + " OUTPUT INSERTED." + column + " " + stmt.body, true, nil
+	}
+
+	return query, false, nil
+}
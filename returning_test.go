@@ -0,0 +1,146 @@
+package squaresql_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecReturning(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		dialect squaresql.Dialect
+		queries string
+		expect  func(mock *squaresqltest.Mock)
+	}{
+		{
+			name:    "returning",
+			dialect: squaresql.Postgres,
+			queries: `
+			-- name: create-user
+			INSERT INTO users (name) VALUES ($1);
+			`,
+			expect: func(mock *squaresqltest.Mock) {
+				mock.ExpectQuerySQL("INSERT INTO users (name) VALUES ($1) RETURNING id").
+					WithArgs("ann").WillReturnRows(squaresqltest.NewRows("id").AddRow(int64(7)))
+			},
+		},
+		{
+			name:    "returning annotated column",
+			dialect: squaresql.SQLite,
+			queries: `
+			-- name: create-user
+			-- returning: user_id
+			INSERT INTO users (name) VALUES (?)
+			`,
+			expect: func(mock *squaresqltest.Mock) {
+				mock.ExpectQuerySQL("INSERT INTO users (name) VALUES (?) RETURNING user_id").
+					WithArgs("ann").WillReturnRows(squaresqltest.NewRows("user_id").AddRow(int64(7)))
+			},
+		},
+		{
+			name:    "output",
+			dialect: squaresql.SQLServer,
+			queries: `
+			-- name: create-user
+			INSERT INTO users (name) VALUES (@name)
+			`,
+			expect: func(mock *squaresqltest.Mock) {
+				mock.ExpectQuerySQL("INSERT INTO users (name) OUTPUT INSERTED.id VALUES (@name)").
+					WithArgs("ann").WillReturnRows(squaresqltest.NewRows("id").AddRow(int64(7)))
+			},
+		},
+		{
+			name:    "output of an upsert",
+			dialect: squaresql.SQLServer,
+			queries: `
+			-- name: create-user
+			-- upsert: key=name
+			INSERT INTO users (name) VALUES (@name)
+			`,
+			expect: func(mock *squaresqltest.Mock) {
+				mock.ExpectQuerySQL("MERGE INTO users AS target USING (VALUES (@name)) AS source (name) ON target.name = source.name WHEN NOT MATCHED THEN INSERT (name) VALUES (source.name) OUTPUT INSERTED.id;").
+					WithArgs("ann").WillReturnRows(squaresqltest.NewRows("id").AddRow(int64(7)))
+			},
+		},
+		{
+			name:    "last insert id",
+			dialect: squaresql.MySQL,
+			queries: `
+			-- name: create-user
+			INSERT INTO users (name) VALUES (?)
+			`,
+			expect: func(mock *squaresqltest.Mock) {
+				mock.ExpectExecSQL("INSERT INTO users (name) VALUES (?)").WithArgs("ann").WillReturnResult(7, 1)
+			},
+		},
+		{
+			name:    "last insert id of an updated row",
+			dialect: squaresql.MySQL,
+			queries: `
+			-- name: create-user
+			-- upsert: key=id
+			INSERT INTO users (id, name) VALUES (7, ?)
+			`,
+			expect: func(mock *squaresqltest.Mock) {
+				// MySQL reports two affected rows for an update, and the id
+				// only through LAST_INSERT_ID(id).
+				mock.ExpectExecSQL("INSERT INTO users (id, name) VALUES (7, ?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), name = VALUES(name)").
+					WithArgs("ann").WillReturnResult(7, 2)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			square, err := squaresql.LoadFromString(tt.queries, squaresql.WithDialect(tt.dialect))
+			assert.NoError(t, err)
+
+			db, mock := squaresqltest.New(t, square)
+			tt.expect(mock)
+
+			id, err := square.ExecReturning(ctx, db, "create-user", "ann")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(7), id)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, int64(1), square.Stats()["create-user"].Calls)
+		})
+	}
+
+	t.Run("no rows", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(`
+		-- name: create-user
+		INSERT INTO users (name) VALUES ($1) ON CONFLICT DO NOTHING RETURNING id
+		`, squaresql.WithDialect(squaresql.Postgres))
+		assert.NoError(t, err)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuery("create-user").WillReturnRows(squaresqltest.NewRows("id"))
+
+		_, err = square.ExecReturning(ctx, db, "create-user", "ann")
+		assert.Equal(t, sql.ErrNoRows, err)
+	})
+
+	t.Run("upsert skipping the row", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(`
+		-- name: create-user
+		-- upsert: key=name
+		INSERT INTO users (name) VALUES ($1)
+		`, squaresql.WithDialect(squaresql.Postgres))
+		assert.NoError(t, err)
+
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectQuerySQL("INSERT INTO users (name) VALUES ($1) ON CONFLICT (name) DO NOTHING RETURNING id").
+			WithArgs("ann").WillReturnRows(squaresqltest.NewRows("id"))
+
+		_, err = square.ExecReturning(ctx, db, "create-user", "ann")
+		assert.Equal(t, sql.ErrNoRows, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,252 @@
+package squaresql
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Balancer selects the replica a read is sent to.
+type Balancer int
+
+const (
+	// RoundRobin sends reads to every replica in turn.
+	RoundRobin Balancer = iota
+	// LeastLatency sends reads to the replica with the lowest average
+	// latency observed so far.
+	LeastLatency
+)
+
+// latencyWeight is the weight of the latest observation in the moving
+// average latency of a replica.
+const latencyWeight = 0.2
+
+// Router dispatches the queries of a SquareSql to a primary database or its
+// read replicas. Queries annotated with `-- readonly: true` go to a replica,
+// queries annotated with `-- readonly: false` to the primary and the others
+// according to IsReadOnly.
+//
+// Reads issued with a context returned by WithSession go to the primary once
+// the session has written, so that it reads its own writes despite
+// replication lag.
+type Router struct {
+	square   *SquareSql
+	primary  *sql.DB
+	replicas []*sql.DB
+
+	mu         sync.Mutex
+	balancer   Balancer
+	stickiness time.Duration
+	next       int
+	latencies  []time.Duration
+}
+
+// NewRouter returns a Router running the queries of square on primary and
+// replicas. Without replicas, every query runs on primary.
+func NewRouter(square *SquareSql, primary *sql.DB, replicas ...*sql.DB) *Router {
+	return &Router{
+		square:    square,
+		primary:   primary,
+		replicas:  replicas,
+		latencies: make([]time.Duration, len(replicas)),
+	}
+}
+
+// SetBalancer sets how reads are spread over the replicas.
+func (r *Router) SetBalancer(balancer Balancer) {
+	r.mu.Lock()
+	r.balancer = balancer
+	r.mu.Unlock()
+}
+
+// SetStickiness sets how long the reads of a session go to the primary after
+// it wrote. A duration of zero, the default, keeps them on the primary for
+// the rest of the session.
+func (r *Router) SetStickiness(d time.Duration) {
+	r.mu.Lock()
+	r.stickiness = d
+	r.mu.Unlock()
+}
+
+// Primary returns the primary database, e.g. to begin a transaction.
+func (r *Router) Primary() *sql.DB {
+	return r.primary
+}
+
+type routerKey int
+
+const (
+	sessionKey routerKey = iota
+	forcePrimaryKey
+)
+
+type session struct {
+	mu        sync.Mutex
+	lastWrite time.Time
+}
+
+// WithSession returns a context whose reads, once a write has been made
+// with it, are routed to the primary.
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey, &session{})
+}
+
+// ForcePrimary returns a context whose queries are all routed to the primary.
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey, true)
+}
+
+// DB returns the database the named query is routed to with ctx, e.g. to
+// prepare it.
+func (r *Router) DB(ctx context.Context, name string) (*sql.DB, error) {
+	db, _, _, err := r.route(ctx, name)
+	return db, err
+}
+
+// route returns the database the named query is routed to, the index of the
+// replica or -1 for the primary, and whether the query writes.
+func (r *Router) route(ctx context.Context, name string) (db *sql.DB, replica int, write bool, err error) {
+	q, err := r.square.lookup(name)
+	if err != nil {
+		return nil, -1, false, err
+	}
+
+	readonly, ok, err := q.flag("readonly")
+	if err != nil {
+		return nil, -1, false, err
+	}
+	if !ok {
+		readonly = IsReadOnly(q.text(), r.square.Dialect())
+	}
+	if !readonly {
+		return r.primary, -1, true, nil
+	}
+	if len(r.replicas) == 0 || ctx.Value(forcePrimaryKey) != nil || r.sticky(ctx) {
+		return r.primary, -1, false, nil
+	}
+
+	replica = r.pick()
+	return r.replicas[replica], replica, false, nil
+}
+
+// sticky reports whether the session of ctx wrote recently enough for its
+// reads to stay on the primary.
+func (r *Router) sticky(ctx context.Context) bool {
+	sess, ok := ctx.Value(sessionKey).(*session)
+	if !ok {
+		return false
+	}
+
+	sess.mu.Lock()
+	lastWrite := sess.lastWrite
+	sess.mu.Unlock()
+	if lastWrite.IsZero() {
+		return false
+	}
+
+	r.mu.Lock()
+	stickiness := r.stickiness
+	r.mu.Unlock()
+
+	return stickiness <= 0 || time.Since(lastWrite) < stickiness
+}
+
+func (r *Router) pick() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.balancer == LeastLatency {
+		best := 0
+		for i, latency := range r.latencies {
+			if latency < r.latencies[best] {
+				best = i
+			}
+		}
+		return best
+	}
+
+	i := r.next
+	r.next = (r.next + 1) % len(r.replicas)
+	return i
+}
+
+// done records the outcome of a call routed with route.
+func (r *Router) done(ctx context.Context, replica int, write bool, start time.Time, err error) {
+	if write && err == nil {
+		if sess, ok := ctx.Value(sessionKey).(*session); ok {
+			sess.mu.Lock()
+			sess.lastWrite = time.Now()
+			sess.mu.Unlock()
+		}
+	}
+	if replica < 0 {
+		return
+	}
+
+	elapsed := time.Since(start)
+	r.mu.Lock()
+	if latency := r.latencies[replica]; latency == 0 {
+		r.latencies[replica] = elapsed
+	} else {
+		r.latencies[replica] = time.Duration(latencyWeight*float64(elapsed) + (1-latencyWeight)*float64(latency))
+	}
+	r.mu.Unlock()
+}
+
+// QueryContext runs the named query on the database it is routed to.
+func (r *Router) QueryContext(ctx context.Context, name string, args ...interface{}) (*sql.Rows, error) {
+	db, replica, write, err := r.route(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	rows, err := r.square.QueryContext(ctx, db, name, args...)
+	r.done(ctx, replica, write, start, err)
+
+	return rows, err
+}
+
+// QueryRowContext runs the named query on the database it is routed to.
+func (r *Router) QueryRowContext(ctx context.Context, name string, args ...interface{}) (*sql.Row, error) {
+	db, replica, write, err := r.route(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	row, err := r.square.QueryRowContext(ctx, db, name, args...)
+	r.done(ctx, replica, write, start, err)
+
+	return row, err
+}
+
+// ExecContext runs the named query on the database it is routed to.
+func (r *Router) ExecContext(ctx context.Context, name string, args ...interface{}) (sql.Result, error) {
+	db, replica, write, err := r.route(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	res, err := r.square.ExecContext(ctx, db, name, args...)
+	r.done(ctx, replica, write, start, err)
+
+	return res, err
+}
+
+// Fetch runs the named query on the database it is routed to and reads all
+// of its rows, as SquareSql.Fetch does.
+func (r *Router) Fetch(ctx context.Context, name string, args ...interface{}) (*ResultSet, error) {
+	db, replica, write, err := r.route(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	rs, err := r.square.Fetch(ctx, db, name, args...)
+	r.done(ctx, replica, write, start, err)
+
+	return rs, err
+}
@@ -0,0 +1,129 @@
+package squaresql_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: get-user
+	SELECT name FROM users WHERE id = ?
+
+	-- name: lock-user
+	SELECT name FROM users WHERE id = ? FOR UPDATE
+
+	-- name: next-id
+	-- readonly: false
+	SELECT nextval('ids')
+
+	-- name: report
+	-- readonly: true
+	SELECT report()
+
+	-- name: rename-user
+	UPDATE users SET name = ? WHERE id = ?
+	`)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	name := func() *squaresqltest.Rows { return squaresqltest.NewRows("name").AddRow("alice") }
+	query := func(t *testing.T, router *squaresql.Router, ctx context.Context, name string, args ...interface{}) {
+		rows, err := router.QueryContext(ctx, name, args...)
+		if assert.NoError(t, err) {
+			assert.NoError(t, rows.Close())
+		}
+	}
+
+	t.Run("routes reads and writes", func(t *testing.T) {
+		primary, primaryMock := squaresqltest.New(t, square)
+		replica, replicaMock := squaresqltest.New(t, square)
+		router := squaresql.NewRouter(square, primary, replica)
+
+		replicaMock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(name())
+		replicaMock.ExpectQuery("report").WillReturnRows(squaresqltest.NewRows("report"))
+		primaryMock.ExpectQuery("lock-user").WithArgs(1).WillReturnRows(name())
+		primaryMock.ExpectQuery("next-id").WillReturnRows(squaresqltest.NewRows("nextval").AddRow(int64(7)))
+		primaryMock.ExpectExec("rename-user").WithArgs("bob", 1).WillReturnResult(0, 1)
+		primaryMock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(name())
+
+		query(t, router, ctx, "get-user", 1)
+		query(t, router, ctx, "report")
+		query(t, router, ctx, "lock-user", 1)
+		query(t, router, ctx, "next-id")
+		_, err := router.ExecContext(ctx, "rename-user", "bob", 1)
+		assert.NoError(t, err)
+		query(t, router, squaresql.ForcePrimary(ctx), "get-user", 1)
+	})
+
+	t.Run("round robin", func(t *testing.T) {
+		primary, _ := squaresqltest.New(t, square)
+		first, firstMock := squaresqltest.New(t, square)
+		second, secondMock := squaresqltest.New(t, square)
+		router := squaresql.NewRouter(square, primary, first, second)
+
+		for i := 0; i < 2; i++ {
+			firstMock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(name())
+			secondMock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(name())
+		}
+		for i := 0; i < 4; i++ {
+			query(t, router, ctx, "get-user", 1)
+		}
+	})
+
+	t.Run("least latency", func(t *testing.T) {
+		primary, _ := squaresqltest.New(t, square)
+		slow, slowMock := squaresqltest.New(t, square)
+		fast, fastMock := squaresqltest.New(t, square)
+		router := squaresql.NewRouter(square, primary, slow, fast)
+		router.SetBalancer(squaresql.LeastLatency)
+
+		slowMock.ExpectQuery("get-user").WithArgs(1).WillDelayFor(50 * time.Millisecond).WillReturnRows(name())
+		fastMock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(name())
+		fastMock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(name())
+		fastMock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(name())
+
+		for i := 0; i < 4; i++ {
+			query(t, router, ctx, "get-user", 1)
+		}
+	})
+
+	t.Run("sticks to the primary after writes", func(t *testing.T) {
+		primary, primaryMock := squaresqltest.New(t, square)
+		replica, replicaMock := squaresqltest.New(t, square)
+		router := squaresql.NewRouter(square, primary, replica)
+		session := squaresql.WithSession(ctx)
+
+		replicaMock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(name())
+		primaryMock.ExpectExec("rename-user").WithArgs("bob", 1).WillReturnResult(0, 1)
+		primaryMock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(name())
+		replicaMock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(name())
+
+		query(t, router, session, "get-user", 1)
+		_, err := router.ExecContext(session, "rename-user", "bob", 1)
+		assert.NoError(t, err)
+		query(t, router, session, "get-user", 1)
+		query(t, router, ctx, "get-user", 1)
+	})
+
+	t.Run("stickiness expires", func(t *testing.T) {
+		primary, primaryMock := squaresqltest.New(t, square)
+		replica, replicaMock := squaresqltest.New(t, square)
+		router := squaresql.NewRouter(square, primary, replica)
+		router.SetStickiness(time.Millisecond)
+		session := squaresql.WithSession(ctx)
+
+		primaryMock.ExpectExec("rename-user").WithArgs("bob", 1).WillReturnResult(0, 1)
+		replicaMock.ExpectQuery("get-user").WithArgs(1).WillReturnRows(name())
+
+		_, err := router.ExecContext(session, "rename-user", "bob", 1)
+		assert.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+		query(t, router, session, "get-user", 1)
+	})
+}
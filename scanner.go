@@ -6,10 +6,39 @@ import (
 	"strings"
 )
 
+// ScanMode controls how the Scanner treats query text.
+type ScanMode int
+
+const (
+	// ScanTrim trims every line and drops empty ones.
+	ScanTrim ScanMode = iota
+	// ScanPreserve keeps query text exactly as written and tracks string
+	// literals, dollar-quoted bodies and block comments so that a name tag is
+	// only recognised outside of them.
+	ScanPreserve
+)
+
 type Scanner struct {
+	// Mode selects how query text is treated; the zero value is ScanTrim.
+	Mode ScanMode
+	// Dialect is used to tokenize query text in ScanPreserve mode.
+	Dialect Dialect
+	// Namespace qualifies the name of every query. A `-- namespace:` header
+	// before the first query takes precedence.
+	Namespace string
+
+	// source is the path of the file being scanned, if any.
+	source        string
+	fileNamespace bool
+	namespace     string
+
 	line    string
-	queries map[string]string
-	current string
+	queries []*Query
+	current *Query
+	lineNo  int
+	// open holds the text of a literal or comment left unterminated at the
+	// end of the previous line.
+	open string
 }
 
 type stateFn func(*Scanner) stateFn
@@ -23,45 +52,184 @@ func getTag(line string) string {
 	return matches[1]
 }
 
+// tag returns the name tag on the current line, ignoring lines that continue
+// a literal or comment in ScanPreserve mode.
+func (s *Scanner) tag() string {
+	if s.Mode == ScanPreserve && len(s.open) > 0 {
+		return ""
+	}
+	return getTag(s.line)
+}
+
+var annotationRe = regexp.MustCompile("^\\s*--\\s*([A-Za-z][A-Za-z0-9_-]*):\\s*(.*?)\\s*$")
+
+func getAnnotation(line string) (key, value string) {
+	matches := annotationRe.FindStringSubmatch(line)
+	if matches == nil {
+		return "", ""
+	}
+	return strings.ToLower(matches[1]), matches[2]
+}
+
+func (s *Scanner) startQuery(name string) {
+	s.current = &Query{Name: qualify(s.namespace, name), File: s.source, Line: s.lineNo}
+	s.queries = append(s.queries, s.current)
+}
+
 func initialState(s *Scanner) stateFn {
-	if tag := getTag(s.line); len(tag) > 0 {
-		s.current = tag
-		return queryState
+	if tag := s.tag(); len(tag) > 0 {
+		s.startQuery(tag)
+		return headerState
+	}
+	if key, value := getAnnotation(s.line); key == "namespace" {
+		s.namespace = value
 	}
 	return initialState
 }
 
+// headerState collects the annotations directly following a name tag.
+func headerState(s *Scanner) stateFn {
+	if tag := s.tag(); len(tag) > 0 {
+		s.startQuery(tag)
+		return headerState
+	}
+	if len(strings.TrimSpace(s.line)) == 0 {
+		return headerState
+	}
+	if key, value := getAnnotation(s.line); len(key) > 0 {
+		s.annotate(key, value)
+		return headerState
+	}
+	s.appendQueryLine()
+	return queryState
+}
+
 func queryState(s *Scanner) stateFn {
-	if tag := getTag(s.line); len(tag) > 0 {
-		s.current = tag
-	} else {
-		s.appendQueryLine()
+	if tag := s.tag(); len(tag) > 0 {
+		s.startQuery(tag)
+		return headerState
 	}
+	s.appendQueryLine()
 	return queryState
 }
 
+func (s *Scanner) annotate(key, value string) {
+	if s.current.Annotations == nil {
+		s.current.Annotations = make(map[string]string)
+	}
+	s.current.Annotations[key] = value
+
+	if key == "dialect" {
+		s.current.Dialect = Dialect(strings.ToLower(value))
+	}
+}
+
 func (s *Scanner) appendQueryLine() {
-	current := s.queries[s.current]
+	if s.Mode == ScanPreserve {
+		s.appendRawLine()
+		return
+	}
+
 	line := strings.Trim(s.line, " \t")
 	if len(line) == 0 {
 		return
 	}
 
-	if len(current) > 0 {
-		current = current + "\n"
+	if len(s.current.SQL) > 0 {
+		s.current.SQL += "\n"
 	}
 
-	current = current + line
-	s.queries[s.current] = current
+	s.current.SQL += line
 }
 
-func (s *Scanner) Run(io *bufio.Scanner) map[string]string {
-	s.queries = make(map[string]string)
+func (s *Scanner) appendRawLine() {
+	if len(s.current.SQL) == 0 && len(strings.TrimSpace(s.line)) == 0 {
+		return
+	}
+
+	if len(s.current.SQL) > 0 {
+		s.current.SQL += "\n"
+	}
+
+	s.current.SQL += s.line
+}
+
+// track records whether the current line leaves a literal or comment open.
+func (s *Scanner) track() {
+	tokens := tokenize(s.open+s.line+"\n", s.Dialect)
+	s.open = ""
+	if n := len(tokens); n > 0 && tokens[n-1].open {
+		s.open = tokens[n-1].text
+	}
+}
+
+// Scan returns every query in the order it was declared, including the
+// dialect-specific variants of a name. Queries without SQL are dropped unless
+// they are annotated, as bulk load targets are.
+func (s *Scanner) Scan(io *bufio.Scanner) []*Query {
+	s.queries = nil
+	s.current = nil
+	s.open = ""
+	s.lineNo = 0
+	s.namespace = s.Namespace
+	if len(s.namespace) == 0 && s.fileNamespace && len(s.source) > 0 {
+		s.namespace = fileNamespace(s.source)
+	}
 
 	for state := initialState; io.Scan(); {
 		s.line = io.Text()
+		s.lineNo++
 		state = state(s)
+		if s.Mode == ScanPreserve {
+			s.track()
+		}
+	}
+
+	queries := make([]*Query, 0, len(s.queries))
+	for _, q := range s.queries {
+		if s.Mode == ScanPreserve {
+			q.SQL = trimTrailingBlankLines(q.SQL)
+		}
+		if len(q.SQL) > 0 || len(q.Annotations) > 0 {
+			q.derive(s.Dialect)
+			queries = append(queries, q)
+		}
+	}
+
+	return queries
+}
+
+// Run returns the text of every query keyed by name. Where a name has
+// dialect-specific variants, the one matching the scanner's Dialect is
+// used, falling back to the generic version.
+func (s *Scanner) Run(io *bufio.Scanner) map[string]string {
+	queries := make(map[string]string)
+	for name, set := range collect(s.Scan(io)) {
+		if q, ok := set.resolve(s.Dialect); ok {
+			queries[name] = q.SQL
+		}
 	}
 
-	return s.queries
+	return queries
+}
+
+func collect(queries []*Query) map[string]querySet {
+	sets := make(map[string]querySet)
+	for _, q := range queries {
+		if sets[q.Name] == nil {
+			sets[q.Name] = make(querySet)
+		}
+		sets[q.Name][q.Dialect] = q
+	}
+	return sets
+}
+
+func trimTrailingBlankLines(text string) string {
+	for {
+		i := strings.LastIndexByte(text, '\n')
+		if i < 0 || len(strings.TrimSpace(text[i:])) > 0 {
+			return text
+		}
+		text = text[:i]
+	}
 }
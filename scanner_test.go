@@ -45,3 +45,108 @@ func TestRun(t *testing.T) {
 
 	assert.Equal(t, queries, exp)
 }
+
+func TestRunPreserve(t *testing.T) {
+	sqlFile := `-- name: create-function
+CREATE FUNCTION touch() RETURNS trigger AS $$
+BEGIN
+
+    NEW.updated_at := now();
+-- name: not-a-tag-inside-a-body
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- name: multi-line-literal
+  SELECT 'first line
+-- name: not-a-tag-inside-a-string
+	indented line' AS text
+/* block comment
+-- name: not-a-tag-inside-a-comment
+*/
+
+-- name: empty-query-should-not-be-stored
+
+-- name: last
+SELECT 1
+`
+
+	exp := map[string]string{
+		"create-function":    "CREATE FUNCTION touch() RETURNS trigger AS $$\nBEGIN\n\n    NEW.updated_at := now();\n-- name: not-a-tag-inside-a-body\n    RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql;",
+		"multi-line-literal": "  SELECT 'first line\n-- name: not-a-tag-inside-a-string\n\tindented line' AS text\n/* block comment\n-- name: not-a-tag-inside-a-comment\n*/",
+		"last":               "SELECT 1",
+	}
+
+	scanner := &Scanner{Mode: ScanPreserve, Dialect: Postgres}
+	queries := scanner.Run(bufio.NewScanner(strings.NewReader(sqlFile)))
+
+	assert.Equal(t, exp, queries)
+}
+
+func TestScanAnnotationsAndVariants(t *testing.T) {
+	sqlFile := `
+	-- name: upsert-user
+	-- dialect: postgres
+	-- Postgres supports ON CONFLICT
+	INSERT INTO users (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name
+	-- name: upsert-user
+	-- dialect: SQLite
+	-- readonly: false
+	INSERT OR REPLACE INTO users (id, name) VALUES (?, ?)
+	-- name: upsert-user
+	INSERT INTO users (id, name) VALUES (?, ?)
+	`
+
+	scanner := &Scanner{}
+	queries := scanner.Scan(bufio.NewScanner(strings.NewReader(sqlFile)))
+	for _, q := range queries {
+		assert.NotEmpty(t, q.Fingerprint)
+		q.Normalized, q.Fingerprint = "", ""
+	}
+
+	exp := []*Query{
+		{
+			Name:        "upsert-user",
+			SQL:         "-- Postgres supports ON CONFLICT\nINSERT INTO users (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name",
+			Dialect:     Postgres,
+			Annotations: map[string]string{"dialect": "postgres"},
+			Line:        2,
+		},
+		{
+			Name:        "upsert-user",
+			SQL:         "INSERT OR REPLACE INTO users (id, name) VALUES (?, ?)",
+			Dialect:     SQLite,
+			Annotations: map[string]string{"dialect": "SQLite", "readonly": "false"},
+			Line:        6,
+		},
+		{
+			Name: "upsert-user",
+			SQL:  "INSERT INTO users (id, name) VALUES (?, ?)",
+			Line: 10,
+		},
+	}
+	assert.Equal(t, exp, queries)
+
+	scanner = &Scanner{Dialect: SQLite}
+	assert.Equal(t, map[string]string{
+		"upsert-user": "INSERT OR REPLACE INTO users (id, name) VALUES (?, ?)",
+	}, scanner.Run(bufio.NewScanner(strings.NewReader(sqlFile))))
+}
+
+func TestScanKeepsAnnotatedQueriesWithoutSQL(t *testing.T) {
+	sqlFile := `
+	-- name: load-products
+	-- bulk: products(id, name)
+
+	-- name: empty
+
+	-- name: count-products
+	SELECT count(*) FROM products
+	`
+
+	scanner := &Scanner{}
+	assert.Equal(t, map[string]string{
+		"load-products":  "",
+		"count-products": "SELECT count(*) FROM products",
+	}, scanner.Run(bufio.NewScanner(strings.NewReader(sqlFile))))
+}
@@ -0,0 +1,80 @@
+package squaresql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ScriptError reports which statement of a script failed.
+type ScriptError struct {
+	Name string
+	// Index is the zero-based position of the failed statement.
+	Index     int
+	Statement string
+	Err       error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("squaresql: statement %d of '%s' failed: %v", e.Index+1, e.Name, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// Statements returns the statements the named query is made of, split
+// according to the configured dialect.
+func (s *SquareSql) Statements(name string) ([]string, error) {
+	query, err := s.lookupQuery(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return SplitStatements(query, s.Dialect()), nil
+}
+
+// ExecScript executes the statements of the named query one by one, stopping
+// at the first failure, which is reported as a *ScriptError.
+func (s *SquareSql) ExecScript(ctx context.Context, db ExecerContext, name string) error {
+	dialect := s.Dialect()
+	err := s.run(ctx, db, name, func(query string) error {
+		for i, statement := range SplitStatements(query, dialect) {
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return &ScriptError{Name: name, Index: i, Statement: statement, Err: err}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		s.invalidateTagsOf(name)
+	}
+
+	return err
+}
+
+// ExecScriptTx is like ExecScript but runs the statements inside a single
+// transaction, which is rolled back if any of them fails.
+func (s *SquareSql) ExecScriptTx(ctx context.Context, db TxBeginner, name string, opts *sql.TxOptions) error {
+	dialect := s.Dialect()
+	err := s.run(ctx, db, name, func(query string) error {
+		tx, err := db.BeginTx(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		for i, statement := range SplitStatements(query, dialect) {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				_ = tx.Rollback()
+				return &ScriptError{Name: name, Index: i, Statement: statement, Err: err}
+			}
+		}
+
+		return tx.Commit()
+	})
+	if err == nil {
+		s.invalidateTagsOf(name)
+	}
+
+	return err
+}
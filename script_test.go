@@ -0,0 +1,48 @@
+package squaresql_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecScriptTx(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: seed
+	INSERT INTO a VALUES (1);
+	INSERT INTO b VALUES (2);
+	`)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+
+	t.Run("commits", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectBegin()
+		mock.ExpectExecSQL("INSERT INTO a VALUES (1)")
+		mock.ExpectExecSQL("INSERT INTO b VALUES (2)")
+		mock.ExpectCommit()
+
+		assert.NoError(t, square.ExecScriptTx(ctx, db, "seed", nil))
+	})
+
+	t.Run("rolls back", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		failure := errors.New("no such table: b")
+		mock.ExpectBegin()
+		mock.ExpectExecSQL("INSERT INTO a VALUES (1)")
+		mock.ExpectExecSQL("INSERT INTO b VALUES (2)").WillReturnError(failure)
+		mock.ExpectRollback()
+
+		err := square.ExecScriptTx(ctx, db, "seed", nil)
+		var scriptErr *squaresql.ScriptError
+		if assert.True(t, errors.As(err, &scriptErr)) {
+			assert.Equal(t, 1, scriptErr.Index)
+		}
+		assert.True(t, errors.Is(err, failure))
+	})
+}
@@ -0,0 +1,81 @@
+package squaresql
+
+import (
+	"strings"
+)
+
+// SplitStatements splits script into its individual statements. Semicolons
+// inside string literals, quoted identifiers, dollar-quoted bodies, comments
+// and BEGIN ... END blocks (trigger and procedure bodies) do not terminate a
+// statement. Terminating semicolons are dropped, as are statements made up of
+// comments only.
+func SplitStatements(script string, dialect Dialect) []string {
+	tokens := tokenize(script, dialect)
+
+	var (
+		statements []string
+		current    strings.Builder
+		words      int
+		depth      int
+		code       bool
+		// afterEnd is set when the previous significant token was END, so
+		// that the CASE of END CASE does not open another block.
+		afterEnd bool
+	)
+
+	flush := func() {
+		if code {
+			statements = append(statements, strings.TrimSpace(current.String()))
+		}
+		current.Reset()
+		words, depth, code, afterEnd = 0, 0, false, false
+	}
+
+	for i, t := range tokens {
+		if t.kind == tokenPunct && t.text == ";" && depth == 0 {
+			flush()
+			continue
+		}
+
+		current.WriteString(t.text)
+		if !t.significant() {
+			continue
+		}
+		code = true
+
+		switch {
+		case t.is("BEGIN"):
+			// A leading BEGIN starts a transaction rather than a block.
+			if words > 0 {
+				depth++
+			}
+		case t.is("CASE"):
+			if !afterEnd {
+				depth++
+			}
+		case t.is("END"):
+			if depth > 0 && !closesControlFlow(tokens[i+1:]) {
+				depth--
+			}
+		}
+		if t.kind == tokenWord {
+			words++
+		}
+		afterEnd = t.is("END")
+	}
+	flush()
+
+	return statements
+}
+
+// closesControlFlow reports whether an END is followed by one of the
+// procedural keywords whose opening counterpart is not tracked.
+func closesControlFlow(rest []token) bool {
+	for _, t := range rest {
+		if !t.significant() {
+			continue
+		}
+		return t.is("IF") || t.is("LOOP") || t.is("WHILE") || t.is("REPEAT") || t.is("FOR")
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package squaresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenizeIsLossless(t *testing.T) {
+	inputs := []string{
+		"SELECT * FROM products WHERE name = 'it''s' -- trailing",
+		"SELECT $body$ ; $body$, $1::text, E'\\'' /* a /* nested */ comment */",
+		"SELECT `weird;name` FROM t WHERE a = \"x\" # hash",
+		"SELECT 'unterminated",
+	}
+
+	for _, dialect := range []Dialect{Generic, Postgres, MySQL, SQLite} {
+		for _, in := range inputs {
+			var b strings.Builder
+			for _, tok := range tokenize(in, dialect) {
+				b.WriteString(tok.text)
+			}
+			assert.Equal(t, in, b.String())
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		script  string
+		want    []string
+	}{
+		{
+			name:    "simple statements",
+			dialect: Generic,
+			script:  "CREATE TABLE a (id int);\nINSERT INTO a VALUES (1);\n",
+			want:    []string{"CREATE TABLE a (id int)", "INSERT INTO a VALUES (1)"},
+		},
+		{
+			name:    "last statement without semicolon",
+			dialect: Generic,
+			script:  "DELETE FROM a; DELETE FROM b",
+			want:    []string{"DELETE FROM a", "DELETE FROM b"},
+		},
+		{
+			name:    "semicolons in literals and comments",
+			dialect: Postgres,
+			script:  "INSERT INTO a VALUES ('x;y'); -- not; here\n/* nor; here */ SELECT \"odd;name\" FROM a;",
+			want:    []string{"INSERT INTO a VALUES ('x;y')", "-- not; here\n/* nor; here */ SELECT \"odd;name\" FROM a"},
+		},
+		{
+			name:    "dollar quoted function body",
+			dialect: Postgres,
+			script: `CREATE FUNCTION f() RETURNS int AS $$
+BEGIN
+  PERFORM 1;
+  RETURN 2;
+END;
+$$ LANGUAGE plpgsql;
+SELECT f();`,
+			want: []string{
+				"CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  PERFORM 1;\n  RETURN 2;\nEND;\n$$ LANGUAGE plpgsql",
+				"SELECT f()",
+			},
+		},
+		{
+			name:    "trigger with begin end block",
+			dialect: SQLite,
+			script: `CREATE TRIGGER t AFTER INSERT ON a BEGIN
+  UPDATE b SET n = CASE WHEN n IS NULL THEN 1 ELSE n + 1 END;
+  DELETE FROM c;
+END;
+INSERT INTO a VALUES (1);`,
+			want: []string{
+				"CREATE TRIGGER t AFTER INSERT ON a BEGIN\n  UPDATE b SET n = CASE WHEN n IS NULL THEN 1 ELSE n + 1 END;\n  DELETE FROM c;\nEND",
+				"INSERT INTO a VALUES (1)",
+			},
+		},
+		{
+			name:    "transaction control",
+			dialect: SQLite,
+			script:  "BEGIN; UPDATE a SET n = 1; END;",
+			want:    []string{"BEGIN", "UPDATE a SET n = 1", "END"},
+		},
+		{
+			name:    "mysql procedure with control flow",
+			dialect: MySQL,
+			script: `CREATE PROCEDURE p() BEGIN
+  IF 1 THEN SELECT 'a\';'; END IF;
+END;
+# comment; only
+CALL p();`,
+			want: []string{
+				"CREATE PROCEDURE p() BEGIN\n  IF 1 THEN SELECT 'a\\';'; END IF;\nEND",
+				"# comment; only\nCALL p()",
+			},
+		},
+		{
+			name:    "mysql procedure with case statement",
+			dialect: MySQL,
+			script:  "CREATE PROCEDURE p() BEGIN CASE x WHEN 1 THEN SELECT 1; END CASE; END; SELECT 2;",
+			want: []string{
+				"CREATE PROCEDURE p() BEGIN CASE x WHEN 1 THEN SELECT 1; END CASE; END",
+				"SELECT 2",
+			},
+		},
+		{
+			name:    "comment only statements are dropped",
+			dialect: Generic,
+			script:  "SELECT 1; -- done\n;",
+			want:    []string{"SELECT 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SplitStatements(tt.script, tt.dialect))
+		})
+	}
+}
+
+func TestExecScript(t *testing.T) {
+	square := &SquareSql{}
+	square.Add("seed", "INSERT INTO a VALUES (1);\nINSERT INTO a VALUES ('2;3');\nINSERT INTO b VALUES (4);")
+
+	ctx := context.Background()
+
+	t.Run("runs statements in order", func(t *testing.T) {
+		var executed []string
+		execer := &ExecerContextMock{
+			ExecContextFunc: func(_ context.Context, query string, _ ...interface{}) (sql.Result, error) {
+				executed = append(executed, query)
+				return result{}, nil
+			},
+		}
+
+		err := square.ExecScript(ctx, execer, "seed")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"INSERT INTO a VALUES (1)",
+			"INSERT INTO a VALUES ('2;3')",
+			"INSERT INTO b VALUES (4)",
+		}, executed)
+	})
+
+	t.Run("reports failed statement", func(t *testing.T) {
+		failure := errors.New("no such table: b")
+		execer := &ExecerContextMock{
+			ExecContextFunc: func(_ context.Context, query string, _ ...interface{}) (sql.Result, error) {
+				if strings.Contains(query, " b ") {
+					return nil, failure
+				}
+				return result{}, nil
+			},
+		}
+
+		err := square.ExecScript(ctx, execer, "seed")
+		var scriptErr *ScriptError
+		if assert.True(t, errors.As(err, &scriptErr)) {
+			assert.Equal(t, 2, scriptErr.Index)
+			assert.Equal(t, "INSERT INTO b VALUES (4)", scriptErr.Statement)
+			assert.True(t, errors.Is(err, failure))
+		}
+		assert.Equal(t, 3, execer.CallNumber())
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		execer := &ExecerContextMock{}
+		assert.Error(t, square.ExecScript(ctx, execer, "missing"))
+		assert.Equal(t, 0, execer.CallNumber())
+	})
+}
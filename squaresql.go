@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
 )
 
 // Preparer is an interface used by Prepare.
@@ -50,21 +52,87 @@ type ExecerContext interface {
 	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 }
 
+// TxBeginner is an interface used by ExecScriptTx.
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// SquareSql holds a set of named queries. It is safe for concurrent use;
+// queries may be added, removed or replaced while lookups are in flight.
 type SquareSql struct {
-	queries map[string]string
+	mu      sync.RWMutex
+	queries map[string]querySet
+	dialect Dialect
+
+	// parent is set on views returned by Sub, which own no queries of their
+	// own and resolve names relative to namespace.
+	parent    *SquareSql
+	namespace string
+
+	// fingerprints indexes query names by fingerprint. It is built lazily and
+	// reset whenever the queries change.
+	fingerprints map[string][]string
+	// cursorKey signs the cursors returned by Paginate.
+	cursorKey []byte
+
+	statsMu sync.Mutex
+	stats   map[string]*QueryStats
+
+	cacheMu     sync.Mutex
+	cache       CacheStore
+	generations map[string]uint64
+
+	flightMu     sync.Mutex
+	singleflight bool
+	flights      flightGroup
+
+	retryMu sync.Mutex
+	retry   *RetryPolicy
+
+	identMu     sync.Mutex
+	identifiers map[string]string
+	rendered    map[string]*renderedQuery
 }
 
-func (s *SquareSql) lookupQuery(name string) (query string, err error) {
-	query, ok := s.queries[name]
+// lookup returns the variant of the named query for the configured dialect.
+func (s *SquareSql) lookup(name string) (*Query, error) {
+	root, prefix := s.scope()
+	root.mu.RLock()
+	q, ok := root.queries[prefix+name].resolve(root.dialect)
+	root.mu.RUnlock()
 	if !ok {
-		err = fmt.Errorf("dotsql: '%s' could not be found", name)
+		return nil, fmt.Errorf("dotsql: '%s' could not be found", name)
+	}
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	return q, nil
+}
+
+func (s *SquareSql) lookupQuery(name string) (string, error) {
+	q, err := s.lookup(name)
+	if err != nil {
+		return "", err
 	}
 
-	return
+	return q.text(), nil
 }
 
+// renderQuery returns the statement run for the named query with ctx.
+func (s *SquareSql) renderQuery(ctx context.Context, name string) (string, error) {
+	q, err := s.lookup(name)
+	if err != nil {
+		return "", err
+	}
+
+	return s.render(ctx, q)
+}
+
+// Prepare prepares the named query, its placeholders replaced by the values
+// set with SetIdentifiers.
 func (s *SquareSql) Prepare(db Preparer, name string) (*sql.Stmt, error) {
-	query, err := s.lookupQuery(name)
+	query, err := s.renderQuery(context.Background(), name)
 	if err != nil {
 		return nil, err
 	}
@@ -72,8 +140,10 @@ func (s *SquareSql) Prepare(db Preparer, name string) (*sql.Stmt, error) {
 	return db.Prepare(query)
 }
 
+// PrepareContext prepares the named query, its placeholders replaced by the
+// values provided by ctx.
 func (s *SquareSql) PrepareContext(ctx context.Context, db PreparerContext, name string) (*sql.Stmt, error) {
-	query, err := s.lookupQuery(name)
+	query, err := s.renderQuery(ctx, name)
 	if err != nil {
 		return nil, err
 	}
@@ -82,99 +152,312 @@ func (s *SquareSql) PrepareContext(ctx context.Context, db PreparerContext, name
 }
 
 func (s *SquareSql) Query(db Queryer, name string, args ...interface{}) (*sql.Rows, error) {
-	query, err := s.lookupQuery(name)
-	if err != nil {
-		return nil, err
-	}
+	var rows *sql.Rows
+	err := s.run(context.Background(), db, name, func(query string) (err error) {
+		rows, err = db.Query(query, args...)
+		return err
+	})
 
-	return db.Query(query, args...)
+	return rows, err
 }
 
 func (s *SquareSql) QueryContext(ctx context.Context, db QueryerContext, name string, args ...interface{}) (*sql.Rows, error) {
-	query, err := s.lookupQuery(name)
-	if err != nil {
-		return nil, err
-	}
+	var rows *sql.Rows
+	err := s.run(ctx, db, name, func(query string) (err error) {
+		rows, err = db.QueryContext(ctx, query, args...)
+		return err
+	})
 
-	return db.QueryContext(ctx, query, args...)
+	return rows, err
 }
 
 func (s *SquareSql) QueryRow(db QueryRower, name string, args ...interface{}) (*sql.Row, error) {
-	query, err := s.lookupQuery(name)
-	if err != nil {
-		return nil, err
-	}
+	var row *sql.Row
+	err := s.run(context.Background(), db, name, func(query string) error {
+		row = db.QueryRow(query, args...)
+		return nil
+	})
 
-	return db.QueryRow(query, args...), nil
+	return row, err
 }
 
 func (s *SquareSql) QueryRowContext(ctx context.Context, db QueryRowerContext, name string, args ...interface{}) (*sql.Row, error) {
-	query, err := s.lookupQuery(name)
-	if err != nil {
-		return nil, err
-	}
+	var row *sql.Row
+	err := s.run(ctx, db, name, func(query string) error {
+		row = db.QueryRowContext(ctx, query, args...)
+		return nil
+	})
 
-	return db.QueryRowContext(ctx, query, args...), nil
+	return row, err
 }
 
 func (s *SquareSql) Exec(db Execer, name string, args ...interface{}) (sql.Result, error) {
-	query, err := s.lookupQuery(name)
-	if err != nil {
-		return nil, err
+	var res sql.Result
+	err := s.run(context.Background(), db, name, func(query string) (err error) {
+		res, err = db.Exec(query, args...)
+		return err
+	})
+	if err == nil {
+		s.invalidateTagsOf(name)
 	}
 
-	return db.Exec(query, args...)
+	return res, err
 }
 
 func (s *SquareSql) ExecContext(ctx context.Context, db ExecerContext, name string, args ...interface{}) (sql.Result, error) {
-	query, err := s.lookupQuery(name)
-	if err != nil {
-		return nil, err
+	var res sql.Result
+	err := s.run(ctx, db, name, func(query string) (err error) {
+		res, err = db.ExecContext(ctx, query, args...)
+		return err
+	})
+	if err == nil {
+		s.invalidateTagsOf(name)
 	}
 
-	return db.ExecContext(ctx, query, args...)
+	return res, err
 }
 
 func (s *SquareSql) Raw(name string) (string, error) {
 	return s.lookupQuery(name)
 }
 
+// SetDialect sets the SQL dialect the queries are written in.
+func (s *SquareSql) SetDialect(dialect Dialect) {
+	root, _ := s.scope()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+
+	root.dialect = dialect
+	names := make([]string, 0, len(root.queries))
+	for name, set := range root.queries {
+		names = append(names, name)
+		if q, ok := set[Generic]; ok {
+			set = set.clone()
+			q = q.clone()
+			q.derive(dialect)
+			set[Generic] = q
+			root.queries[name] = set
+		}
+	}
+	root.fingerprints = nil
+	root.invalidateNames(names...)
+}
+
+// Dialect returns the configured SQL dialect.
+func (s *SquareSql) Dialect() Dialect {
+	root, _ := s.scope()
+	root.mu.RLock()
+	defer root.mu.RUnlock()
+
+	return root.dialect
+}
+
+// QueryMap returns a copy of the loaded queries keyed by name, using the
+// variant for the configured dialect where one exists.
 func (s *SquareSql) QueryMap() map[string]string {
-	return s.queries
+	sets, dialect := s.snapshot()
+
+	queries := make(map[string]string, len(sets))
+	for name, set := range sets {
+		if q, ok := set.resolve(dialect); ok {
+			queries[name] = q.text()
+		}
+	}
+
+	return queries
+}
+
+// Lookup returns a copy of the variant of the named query used for the
+// configured dialect.
+func (s *SquareSql) Lookup(name string) (*Query, error) {
+	q, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	q = q.clone()
+	q.Name = name
+	return q, nil
 }
 
-func Load(r io.Reader) (*SquareSql, error) {
+// Add registers query under name as its generic version, overwriting any
+// generic query with the same name.
+func (s *SquareSql) Add(name, query string) {
+	s.AddQuery(&Query{Name: name, SQL: query})
+}
+
+// AddQuery registers a copy of q, overwriting any variant with the same name
+// and dialect.
+func (s *SquareSql) AddQuery(q *Query) {
+	root, prefix := s.scope()
+	q = q.clone()
+	q.Name = prefix + q.Name
+
+	root.mu.Lock()
+	defer root.mu.Unlock()
+
+	q.derive(root.dialect)
+	root.fingerprints = nil
+
+	if root.queries == nil {
+		root.queries = make(map[string]querySet)
+	}
+	set := root.queries[q.Name].clone()
+	set[q.Dialect] = q
+	root.queries[q.Name] = set
+	root.invalidateNames(q.Name)
+}
+
+// Remove deletes the query registered under name, including all its variants.
+func (s *SquareSql) Remove(name string) {
+	root, prefix := s.scope()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+
+	delete(root.queries, prefix+name)
+	root.fingerprints = nil
+	root.invalidateNames(prefix + name)
+}
+
+// Replace atomically swaps the whole set of queries for generic versions of
+// queries. On a view returned by Sub only the queries of its namespace are
+// replaced.
+func (s *SquareSql) Replace(queries map[string]string) {
+	root, prefix := s.scope()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+
+	replacement := make(map[string]querySet, len(queries))
+	for name, set := range genericSets(queries, root.dialect) {
+		set[Generic].Name = prefix + name
+		replacement[prefix+name] = set
+	}
+
+	var names []string
+	for name, set := range root.queries {
+		if !strings.HasPrefix(name, prefix) {
+			replacement[name] = set
+		} else if _, ok := replacement[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name := range replacement {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	root.queries = replacement
+	root.fingerprints = nil
+	root.invalidateNames(names...)
+}
+
+// MissingVariants reports, for every query without a generic version, the
+// dialects among dialects it has no variant for. When no dialects are given
+// the configured dialect is checked.
+func (s *SquareSql) MissingVariants(dialects ...Dialect) map[string][]Dialect {
+	sets, dialect := s.snapshot()
+	if len(dialects) == 0 {
+		dialects = []Dialect{dialect}
+	}
+
+	missing := make(map[string][]Dialect)
+	for name, set := range sets {
+		for _, dialect := range dialects {
+			if _, ok := set.resolve(dialect); !ok {
+				missing[name] = append(missing[name], dialect)
+			}
+		}
+	}
+
+	return missing
+}
+
+// LoadOption configures the Scanner used to parse query files.
+type LoadOption func(*Scanner)
+
+// WithScanMode sets how query text is scanned.
+func WithScanMode(mode ScanMode) LoadOption {
+	return func(s *Scanner) {
+		s.Mode = mode
+	}
+}
+
+// WithDialect sets the dialect used to tokenize queries. The loaded
+// SquareSql is configured with the same dialect.
+func WithDialect(dialect Dialect) LoadOption {
+	return func(s *Scanner) {
+		s.Dialect = dialect
+	}
+}
+
+// WithNamespace qualifies the name of every loaded query with namespace,
+// unless the file declares its own `-- namespace:` header.
+func WithNamespace(namespace string) LoadOption {
+	return func(s *Scanner) {
+		s.Namespace = namespace
+	}
+}
+
+// WithFileNamespace makes LoadFromFile qualify query names with the base
+// name of the file without its extension, so that queries in users.sql are
+// looked up as "users.<name>".
+func WithFileNamespace() LoadOption {
+	return func(s *Scanner) {
+		s.fileNamespace = true
+	}
+}
+
+func withSource(path string) LoadOption {
+	return func(s *Scanner) {
+		s.source = path
+	}
+}
+
+func Load(r io.Reader, opts ...LoadOption) (*SquareSql, error) {
 	scanner := &Scanner{}
-	queries := scanner.Run(bufio.NewScanner(r))
-	squaresql := &SquareSql{queries: queries}
+	for _, opt := range opts {
+		opt(scanner)
+	}
+	queries := scanner.Scan(bufio.NewScanner(r))
+	squaresql := &SquareSql{queries: collect(queries), dialect: scanner.Dialect}
 	return squaresql, nil
 }
 
-func LoadFromString(sql string) (*SquareSql, error) {
+func LoadFromString(sql string, opts ...LoadOption) (*SquareSql, error) {
 	buf := bytes.NewBufferString(sql)
-	return Load(buf)
+	return Load(buf, opts...)
 }
 
-func LoadFromFile(sqlFile string) (*SquareSql, error) {
+func LoadFromFile(sqlFile string, opts ...LoadOption) (*SquareSql, error) {
 	f, err := os.Open(sqlFile)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	return Load(f)
+	return Load(f, append([]LoadOption{withSource(sqlFile)}, opts...)...)
 }
 
+// Merge combines the queries of dots into a new SquareSql. Later arguments
+// take precedence for queries sharing a name and dialect. The result uses
+// the dialect of the first argument.
 func Merge(dots ...*SquareSql) *SquareSql {
-	queries := make(map[string]string)
+	merged := &SquareSql{queries: make(map[string]querySet)}
 
-	for _, dot := range dots {
-		for k, v := range dot.QueryMap() {
-			queries[k] = v
+	for i, dot := range dots {
+		sets, dialect := dot.snapshot()
+		if i == 0 {
+			merged.dialect = dialect
+		}
+		for name, set := range sets {
+			if merged.queries[name] == nil {
+				merged.queries[name] = make(querySet)
+			}
+			for dialect, q := range set {
+				merged.queries[name][dialect] = q
+			}
 		}
 	}
 
-	return &SquareSql{
-		queries: queries,
-	}
+	return merged
 }
@@ -3,16 +3,17 @@ package squaresql
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"strings"
+	"sync"
 	"testing"
 )
 
 func TestPrepare(t *testing.T) {
-	square := SquareSql{
-		queries: map[string]string{"select": "SELECT * from products"},
-	}
+	square := &SquareSql{}
+	square.Replace(map[string]string{"select": "SELECT * from products"})
 
 	preparerStub := func(err error) *PreparerMock {
 		return &PreparerMock{
@@ -27,7 +28,7 @@ func TestPrepare(t *testing.T) {
 
 	tests := []struct {
 		name          string
-		square        SquareSql
+		square        *SquareSql
 		preparerStub  func(err error) *PreparerMock
 		prCallsNumber int
 		psArg         error
@@ -82,9 +83,8 @@ func TestPrepare(t *testing.T) {
 }
 
 func TestPrepareContext(t *testing.T) {
-	square := SquareSql{
-		queries: map[string]string{"select": "SELECT * from products"},
-	}
+	square := &SquareSql{}
+	square.Replace(map[string]string{"select": "SELECT * from products"})
 
 	preparerContextStub := func(err error) *PreparerContextMock {
 		return &PreparerContextMock{
@@ -101,7 +101,7 @@ func TestPrepareContext(t *testing.T) {
 
 	tests := []struct {
 		name          string
-		square        SquareSql
+		square        *SquareSql
 		preparerStub  func(err error) *PreparerContextMock
 		prCallsNumber int
 		psArg         error
@@ -156,9 +156,8 @@ func TestPrepareContext(t *testing.T) {
 }
 
 func TestQuery(t *testing.T) {
-	square := SquareSql{
-		queries: map[string]string{"select": "SELECT * from products WHERE id = ?"},
-	}
+	square := &SquareSql{}
+	square.Replace(map[string]string{"select": "SELECT * from products WHERE id = ?"})
 
 	queryerStub := func(err error) *QueryerMock {
 		return &QueryerMock{
@@ -173,7 +172,7 @@ func TestQuery(t *testing.T) {
 
 	tests := []struct {
 		name          string
-		square        SquareSql
+		square        *SquareSql
 		queryerStub   func(err error) *QueryerMock
 		prCallsNumber int
 		psArg         error
@@ -228,9 +227,8 @@ func TestQuery(t *testing.T) {
 }
 
 func TestQueryContext(t *testing.T) {
-	square := SquareSql{
-		queries: map[string]string{"select": "SELECT * from products WHERE id = ?"},
-	}
+	square := &SquareSql{}
+	square.Replace(map[string]string{"select": "SELECT * from products WHERE id = ?"})
 
 	queryerContextStub := func(err error) *QueryerContextMock {
 		return &QueryerContextMock{
@@ -247,7 +245,7 @@ func TestQueryContext(t *testing.T) {
 
 	tests := []struct {
 		name               string
-		square             SquareSql
+		square             *SquareSql
 		queryerContextStub func(err error) *QueryerContextMock
 		prCallsNumber      int
 		psArg              error
@@ -302,9 +300,8 @@ func TestQueryContext(t *testing.T) {
 }
 
 func TestQueryRow(t *testing.T) {
-	square := SquareSql{
-		queries: map[string]string{"select": "SELECT * from products WHERE id = ?"},
-	}
+	square := &SquareSql{}
+	square.Replace(map[string]string{"select": "SELECT * from products WHERE id = ?"})
 
 	queryRowerStub := func(success bool) *QueryRowerMock {
 		return &QueryRowerMock{
@@ -319,7 +316,7 @@ func TestQueryRow(t *testing.T) {
 
 	tests := []struct {
 		name           string
-		square         SquareSql
+		square         *SquareSql
 		queryRowerStub func(success bool) *QueryRowerMock
 		prCallsNumber  int
 		psArg          bool
@@ -373,9 +370,8 @@ func TestQueryRow(t *testing.T) {
 }
 
 func TestQueryRowContext(t *testing.T) {
-	square := SquareSql{
-		queries: map[string]string{"select": "SELECT * from products WHERE id = ?"},
-	}
+	square := &SquareSql{}
+	square.Replace(map[string]string{"select": "SELECT * from products WHERE id = ?"})
 
 	queryRowerContextStub := func(success bool) *QueryRowerContextMock {
 		return &QueryRowerContextMock{
@@ -392,7 +388,7 @@ func TestQueryRowContext(t *testing.T) {
 
 	tests := []struct {
 		name                  string
-		square                SquareSql
+		square                *SquareSql
 		queryRowerContextStub func(success bool) *QueryRowerContextMock
 		prCallsNumber         int
 		psArg                 bool
@@ -456,9 +452,8 @@ func (r result) RowsAffected() (int64, error) {
 }
 
 func TestExec(t *testing.T) {
-	square := SquareSql{
-		queries: map[string]string{"select": "SELECT * from products WHERE id = ?"},
-	}
+	square := &SquareSql{}
+	square.Replace(map[string]string{"select": "SELECT * from products WHERE id = ?"})
 
 	execerStub := func(err error) *ExecerMock {
 		return &ExecerMock{
@@ -473,7 +468,7 @@ func TestExec(t *testing.T) {
 
 	tests := []struct {
 		name          string
-		square        SquareSql
+		square        *SquareSql
 		execerStub    func(err error) *ExecerMock
 		prCallsNumber int
 		psArg         error
@@ -528,9 +523,8 @@ func TestExec(t *testing.T) {
 }
 
 func TestExecContext(t *testing.T) {
-	square := SquareSql{
-		queries: map[string]string{"select": "SELECT * from products WHERE id = ?"},
-	}
+	square := &SquareSql{}
+	square.Replace(map[string]string{"select": "SELECT * from products WHERE id = ?"})
 
 	execerContextStub := func(err error) *ExecerContextMock {
 		return &ExecerContextMock{
@@ -547,7 +541,7 @@ func TestExecContext(t *testing.T) {
 
 	tests := []struct {
 		name              string
-		square            SquareSql
+		square            *SquareSql
 		execerContextStub func(err error) *ExecerContextMock
 		prCallsNumber     int
 		psArg             error
@@ -611,7 +605,7 @@ func TestLoad(t *testing.T) {
 
 	q, err := Load(strings.NewReader(sqlFile))
 	assert.NoError(t, err)
-	assert.Equal(t, q.queries["all-products"], expectedQuery)
+	assert.Equal(t, q.queries["all-products"][Generic].SQL, expectedQuery)
 
 	raw, err := q.Raw("all-products")
 	assert.NoError(t, err)
@@ -653,3 +647,184 @@ func TestMergeHaveBothQueries(t *testing.T) {
 	got := c.QueryMap()
 	assert.Equal(t, got, expectedQueryMap)
 }
+
+func TestAddRemoveReplace(t *testing.T) {
+	square := &SquareSql{}
+
+	square.Add("select", "SELECT * from products")
+	raw, err := square.Raw("select")
+	assert.NoError(t, err)
+	assert.Equal(t, raw, "SELECT * from products")
+
+	square.Add("select", "SELECT id from products")
+	raw, err = square.Raw("select")
+	assert.NoError(t, err)
+	assert.Equal(t, raw, "SELECT id from products")
+
+	square.Remove("select")
+	_, err = square.Raw("select")
+	assert.Error(t, err)
+
+	replacement := map[string]string{"insert": "INSERT INTO products (?, ?, ?)"}
+	square.Replace(replacement)
+	replacement["insert"] = "changed by caller"
+	assert.Equal(t, square.QueryMap(), map[string]string{"insert": "INSERT INTO products (?, ?, ?)"})
+}
+
+func TestQueryMapReturnsCopy(t *testing.T) {
+	square := &SquareSql{}
+	square.Add("select", "SELECT * from products")
+
+	got := square.QueryMap()
+	got["select"] = "DELETE FROM products"
+	got["insert"] = "INSERT INTO products (?, ?, ?)"
+
+	assert.Equal(t, square.QueryMap(), map[string]string{"select": "SELECT * from products"})
+}
+
+func TestConcurrentLookupsAndUpdates(t *testing.T) {
+	square := &SquareSql{}
+	square.Add("select", "SELECT * from products")
+
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("query-%d-%d", i, j)
+				square.Add(name, "SELECT 1")
+				if j%3 == 0 {
+					square.Remove(name)
+				}
+				if j%25 == 0 {
+					square.Replace(map[string]string{"select": "SELECT * from products"})
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			execer := &ExecerContextMock{
+				ExecContextFunc: func(_ context.Context, _ string, _ ...interface{}) (sql.Result, error) {
+					return result{}, nil
+				},
+			}
+			for j := 0; j < 100; j++ {
+				_, err := square.ExecContext(ctx, execer, "select")
+				assert.NoError(t, err)
+				_ = square.QueryMap()
+			}
+		}()
+	}
+	wg.Wait()
+
+	raw, err := square.Raw("select")
+	assert.NoError(t, err)
+	assert.Equal(t, raw, "SELECT * from products")
+}
+
+func TestLoadWithOptions(t *testing.T) {
+	sqlFile := "-- name: padded\n  SELECT 'a\n\n  b'\n"
+
+	q, err := LoadFromString(sqlFile, WithScanMode(ScanPreserve), WithDialect(SQLite))
+	assert.NoError(t, err)
+	assert.Equal(t, q.Dialect(), SQLite)
+
+	raw, err := q.Raw("padded")
+	assert.NoError(t, err)
+	assert.Equal(t, raw, "  SELECT 'a\n\n  b'")
+}
+
+func TestDialectVariants(t *testing.T) {
+	q, err := LoadFromString(`
+	-- name: upsert-user
+	-- dialect: postgres
+	INSERT INTO users VALUES ($1) ON CONFLICT DO NOTHING
+	-- name: upsert-user
+	INSERT INTO users VALUES (?)
+	-- name: now
+	-- dialect: postgres
+	SELECT now()
+	-- name: now
+	-- dialect: sqlite
+	SELECT datetime('now')
+	`)
+	assert.NoError(t, err)
+
+	raw, err := q.Raw("upsert-user")
+	assert.NoError(t, err)
+	assert.Equal(t, raw, "INSERT INTO users VALUES (?)")
+
+	_, err = q.Raw("now")
+	assert.Error(t, err)
+
+	q.SetDialect(Postgres)
+	raw, err = q.Raw("upsert-user")
+	assert.NoError(t, err)
+	assert.Equal(t, raw, "INSERT INTO users VALUES ($1) ON CONFLICT DO NOTHING")
+
+	query, err := q.Lookup("now")
+	assert.NoError(t, err)
+	assert.Equal(t, query.SQL, "SELECT now()")
+	assert.Equal(t, query.Dialect, Postgres)
+	assert.Equal(t, query.Annotation("Dialect"), "postgres")
+
+	q.SetDialect(SQLite)
+	assert.Equal(t, q.QueryMap(), map[string]string{
+		"upsert-user": "INSERT INTO users VALUES (?)",
+		"now":         "SELECT datetime('now')",
+	})
+
+	assert.Equal(t, q.MissingVariants(Postgres, SQLite, MySQL), map[string][]Dialect{
+		"now": {MySQL},
+	})
+	assert.Empty(t, q.MissingVariants())
+}
+
+func TestMergeKeepsVariants(t *testing.T) {
+	a, err := LoadFromString("-- name: now\n-- dialect: postgres\nSELECT now()", WithDialect(Postgres))
+	assert.NoError(t, err)
+
+	b, err := LoadFromString("-- name: now\nSELECT CURRENT_TIMESTAMP")
+	assert.NoError(t, err)
+
+	c := Merge(a, b)
+	assert.Equal(t, c.Dialect(), Postgres)
+	assert.Equal(t, c.QueryMap(), map[string]string{"now": "SELECT now()"})
+
+	c.SetDialect(MySQL)
+	assert.Equal(t, c.QueryMap(), map[string]string{"now": "SELECT CURRENT_TIMESTAMP"})
+}
+
+func TestStats(t *testing.T) {
+	square := &SquareSql{}
+	square.Add("users.select", "SELECT * from users")
+
+	execer := &ExecerMock{
+		ExecFunc: func(_ string, _ ...interface{}) (sql.Result, error) {
+			return nil, errors.New("critical error")
+		},
+	}
+	_, err := square.Sub("users").Exec(execer, "select")
+	assert.Error(t, err)
+	_, err = square.Exec(execer, "users.select")
+	assert.Error(t, err)
+	_, err = square.Exec(execer, "missing")
+	assert.Error(t, err)
+
+	stats := square.Stats()
+	assert.Len(t, stats, 1)
+	qs := stats["users.select"]
+	assert.Equal(t, int64(2), qs.Calls)
+	assert.Equal(t, int64(2), qs.Errors)
+	assert.Equal(t, "critical error", qs.LastError)
+	assert.False(t, qs.LastCalled.IsZero())
+	assert.True(t, qs.MaxDuration <= qs.TotalDuration)
+
+	assert.Contains(t, square.Sub("users").Stats(), "select")
+
+	square.ResetStats()
+	assert.Empty(t, square.Stats())
+}
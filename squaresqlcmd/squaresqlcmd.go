@@ -0,0 +1,340 @@
+// Package squaresqlcmd implements the squaresql command, which inspects the
+// named queries of SQL files:
+//
+//	squaresql usage [-dialect d] [-namespaces] [-table t [-column c]] [-json] files...
+//	squaresql explain -driver name -dsn dsn -dialect d [-query name] [-args name=json]
+//		[-golden dir [-update] [-min-rows n]] [-json] files...
+//
+// The explain command connects through a database/sql driver linked into the
+// binary running it. The command in cmd/squaresql links none, so that the
+// module does not depend on any driver; a program linking the drivers it
+// needs runs the command with Main:
+//
+//	package main
+//
+//	import (
+//		"os"
+//
+//		"github.com/allapospelova/squaresql/squaresqlcmd"
+//		_ "github.com/lib/pq"
+//	)
+//
+//	func main() {
+//		os.Exit(squaresqlcmd.Main(os.Args[1:], os.Stdout, os.Stderr))
+//	}
+package squaresqlcmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/allapospelova/squaresql"
+)
+
+const usageText = `usage: squaresql <command> [flags] files...
+
+commands:
+  usage    report the tables and columns read and written by each query
+  explain  show the plans of queries and check them against golden files
+
+explain needs the database/sql driver named by -driver to be linked into the
+binary. The squaresql command links none: build a program importing the driver
+that calls squaresqlcmd.Main, as described by
+go doc github.com/allapospelova/squaresql/squaresqlcmd.
+`
+
+type command func(args []string, stdout, stderr io.Writer) int
+
+var commands = map[string]command{
+	"usage":   usageCommand,
+	"explain": explainCommand,
+}
+
+// linked reports whether the database/sql driver name is linked into the
+// binary.
+func linked(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Main runs the squaresql command with args, the arguments following the
+// program name, and returns its exit status.
+func Main(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usageText)
+		return 2
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "squaresql: unknown command %q\n\n%s", args[0], usageText)
+		return 2
+	}
+	return cmd(args[1:], stdout, stderr)
+}
+
+// loadFlags are the flags shared by commands loading SQL files.
+type loadFlags struct {
+	dialect    string
+	namespaces bool
+}
+
+func (f *loadFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dialect, "dialect", "", "SQL dialect of the files: postgres, mysql or sqlite")
+	fs.BoolVar(&f.namespaces, "namespaces", false, "qualify query names with the base name of their file")
+}
+
+func (f *loadFlags) load(files []string) (*squaresql.SquareSql, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no SQL files given")
+	}
+
+	opts := []squaresql.LoadOption{squaresql.WithDialect(squaresql.Dialect(f.dialect))}
+	if f.namespaces {
+		opts = append(opts, squaresql.WithFileNamespace())
+	}
+
+	var dots []*squaresql.SquareSql
+	for _, file := range files {
+		dot, err := squaresql.LoadFromFile(file, opts...)
+		if err != nil {
+			return nil, err
+		}
+		dots = append(dots, dot)
+	}
+	return squaresql.Merge(dots...), nil
+}
+
+func usageCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("usage", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		load   loadFlags
+		table  = fs.String("table", "", "only list the queries using this table")
+		column = fs.String("column", "", "with -table, only list the queries using this column")
+		asJSON = fs.Bool("json", false, "write the report as JSON")
+	)
+	load.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	square, err := load.load(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "squaresql: %v\n", err)
+		return 1
+	}
+
+	usage := square.Usage()
+	if len(*table) > 0 {
+		for name, u := range usage {
+			if !u.Uses(*table, *column) {
+				delete(usage, name)
+			}
+		}
+	} else if len(*column) > 0 {
+		fmt.Fprintln(stderr, "squaresql: -column requires -table")
+		return 2
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(usage); err != nil {
+			fmt.Fprintf(stderr, "squaresql: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	names := make([]string, 0, len(usage))
+	for name := range usage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintln(stdout, name)
+		writeTables(stdout, "reads", usage[name].Reads)
+		writeTables(stdout, "writes", usage[name].Writes)
+	}
+	return 0
+}
+
+func writeTables(w io.Writer, verb string, tables []squaresql.TableUsage) {
+	for _, t := range tables {
+		if len(t.Columns) == 0 {
+			fmt.Fprintf(w, "  %-6s  %s\n", verb, t.Table)
+			continue
+		}
+		fmt.Fprintf(w, "  %-6s  %s (%s)\n", verb, t.Table, strings.Join(t.Columns, ", "))
+	}
+}
+
+// queryArgs collects the -args flags, each a query name and a JSON array of
+// its arguments.
+type queryArgs map[string][]interface{}
+
+func (a queryArgs) String() string {
+	return ""
+}
+
+func (a queryArgs) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i < 0 {
+		return fmt.Errorf("expected name=[args...], got %q", value)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(value[i+1:]))
+	dec.UseNumber()
+	var args []interface{}
+	if err := dec.Decode(&args); err != nil {
+		return fmt.Errorf("arguments of %s: %v", value[:i], err)
+	}
+	for j, arg := range args {
+		if n, ok := arg.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				args[j] = i
+			} else if f, err := n.Float64(); err == nil {
+				args[j] = f
+			}
+		}
+	}
+
+	a[value[:i]] = args
+	return nil
+}
+
+func explainCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		load      loadFlags
+		queryArgs = make(queryArgs)
+		driver    = fs.String("driver", "", "database/sql driver name")
+		dsn       = fs.String("dsn", "", "data source name")
+		query     = fs.String("query", "", "only explain this query")
+		golden    = fs.String("golden", "", "directory of golden plan files to check plans against")
+		update    = fs.Bool("update", false, "with -golden, record the current plans")
+		minRows   = fs.Float64("min-rows", 0, "with -golden, ignore new full scans of fewer estimated rows")
+		asJSON    = fs.Bool("json", false, "write the plans as JSON")
+	)
+	load.register(fs)
+	fs.Var(queryArgs, "args", "arguments of a query as name=[json values], may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(*driver) == 0 || len(*dsn) == 0 {
+		fmt.Fprintln(stderr, "squaresql: -driver and -dsn are required")
+		return 2
+	}
+
+	square, err := load.load(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "squaresql: %v\n", err)
+		return 1
+	}
+	db, err := sql.Open(*driver, *dsn)
+	if err != nil {
+		fmt.Fprintf(stderr, "squaresql: %v\n", err)
+		if !linked(*driver) {
+			fmt.Fprintf(stderr, "\n%s", usageText)
+		}
+		return 1
+	}
+	defer db.Close()
+
+	names := square.Names()
+	if len(*query) > 0 {
+		names = []string{*query}
+	}
+
+	ctx := context.Background()
+	baseline := &squaresql.PlanBaseline{Dir: *golden, Update: *update, MinRows: *minRows}
+	var (
+		plans  []*squaresql.Plan
+		failed bool
+	)
+	for _, name := range names {
+		plan, err := square.Explain(ctx, db, name, queryArgs[name]...)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			failed = true
+			continue
+		}
+		plans = append(plans, plan)
+
+		if len(*golden) == 0 {
+			continue
+		}
+		regressions, err := baseline.Compare(plan)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			failed = true
+		}
+		for _, r := range regressions {
+			fmt.Fprintf(stderr, "regression: %s\n", r)
+			failed = true
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plans); err != nil {
+			fmt.Fprintf(stderr, "squaresql: %v\n", err)
+			return 1
+		}
+	} else {
+		for _, plan := range plans {
+			fmt.Fprintln(stdout, plan.Query)
+			writePlanNodes(stdout, plan.Nodes, "  ")
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func writePlanNodes(w io.Writer, nodes []*squaresql.PlanNode, indent string) {
+	for _, n := range nodes {
+		line := n.Operation
+		if len(n.Detail) > 0 && strings.HasPrefix(n.Detail, n.Operation) {
+			line = n.Detail
+		} else {
+			if len(n.Table) > 0 {
+				line += " on " + n.Table
+			}
+			if len(n.Index) > 0 {
+				line += " using " + n.Index
+			}
+			if len(n.Detail) > 0 {
+				line += " (" + n.Detail + ")"
+			}
+		}
+		if n.FullScan {
+			line += " [full scan]"
+		}
+		if n.Rows > 0 {
+			line += fmt.Sprintf(" rows=%g", n.Rows)
+		}
+		if n.Cost > 0 {
+			line += fmt.Sprintf(" cost=%g", n.Cost)
+		}
+
+		fmt.Fprintln(w, indent+line)
+		writePlanNodes(w, n.Children, indent+"  ")
+	}
+}
@@ -0,0 +1,125 @@
+package squaresqlcmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+const usersSQL = `
+-- name: get-user
+SELECT id, name FROM users WHERE id = ?
+
+-- name: rename-user
+UPDATE users SET name = ? WHERE id = ?
+
+-- name: list-orders
+SELECT o.id FROM orders o WHERE o.user_id = ?
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUsage(t *testing.T) {
+	path := writeFile(t, "users.sql", usersSQL)
+
+	for _, tt := range []struct {
+		name string
+		args []string
+		code int
+		out  string
+	}{
+		{
+			name: "report",
+			args: []string{"usage", path},
+			out: `get-user
+  reads   users (id, name)
+list-orders
+  reads   orders (id, user_id)
+rename-user
+  reads   users (id)
+  writes  users (name)
+`,
+		},
+		{
+			name: "table",
+			args: []string{"usage", "-table", "users", "-column", "name", path},
+			out: `get-user
+  reads   users (id, name)
+rename-user
+  reads   users (id)
+  writes  users (name)
+`,
+		},
+		{
+			name: "namespaces",
+			args: []string{"usage", "-namespaces", "-table", "orders", path},
+			out: `users.list-orders
+  reads   orders (id, user_id)
+`,
+		},
+		{name: "no files", args: []string{"usage"}, code: 1},
+		{name: "column without table", args: []string{"usage", "-column", "id", path}, code: 2},
+		{name: "unknown command", args: []string{"lint", path}, code: 2},
+		{name: "no command", code: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			assert.Equal(t, tt.code, Main(tt.args, &stdout, &stderr), stderr.String())
+			if tt.code == 0 {
+				assert.Equal(t, tt.out, stdout.String())
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	path := writeFile(t, "users.sql", usersSQL)
+	golden := t.TempDir()
+
+	square, err := squaresql.LoadFromString(usersSQL)
+	assert.NoError(t, err)
+	// The command opens the fake databases through their registered driver.
+	_, recordedMock := squaresqltest.New(t, square)
+	_, regressedMock := squaresqltest.New(t, square)
+
+	plan := func(detail string) *squaresqltest.Rows {
+		return squaresqltest.NewRows("id", "parent", "notused", "detail").AddRow(int64(2), int64(0), int64(0), detail)
+	}
+	explain := "EXPLAIN QUERY PLAN SELECT id, name FROM users WHERE id = ?"
+	recordedMock.ExpectQuerySQL(explain).WithArgs(int64(1)).WillReturnRows(plan("SEARCH users USING INTEGER PRIMARY KEY (rowid=?)"))
+	regressedMock.ExpectQuerySQL(explain).WithArgs(int64(1)).WillReturnRows(plan("SCAN users"))
+
+	args := func(dsn string) []string {
+		return []string{"explain", "-driver", squaresqltest.DriverName, "-dsn", dsn, "-dialect", "sqlite",
+			"-query", "get-user", "-args", "get-user=[1]", "-golden", golden, path}
+	}
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, Main(args(recordedMock.DSN()), &stdout, &stderr), stderr.String())
+	assert.Equal(t, "get-user\n  SEARCH users USING INTEGER PRIMARY KEY (rowid=?)\n", stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, 1, Main(args(regressedMock.DSN()), &stdout, &stderr))
+	assert.Equal(t, "get-user\n  SCAN users [full scan]\n", stdout.String())
+	assert.Contains(t, stderr.String(), "regression: get-user: new full scan of users")
+
+	assert.Equal(t, 2, Main([]string{"explain", path}, &stdout, &stderr))
+
+	t.Run("driver not linked", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 1, Main([]string{"explain", "-driver", "nosuch", "-dsn", "x", path}, &stdout, &stderr))
+		assert.Contains(t, stderr.String(), "unknown driver")
+		assert.Contains(t, stderr.String(), "squaresqlcmd.Main")
+	})
+}
@@ -0,0 +1,148 @@
+// Package squaresqlhttp provides an http.Handler exposing the queries loaded
+// in a SquareSql together with their execution statistics. It is meant to be
+// mounted on an internal admin port:
+//
+//	mux.Handle("/debug/queries", squaresqlhttp.Handler(square))
+//
+// The page lists every query with its source location, annotations and
+// statistics. The q parameter filters queries by name or tag, and JSON is
+// served instead of HTML when format=json is given or the request accepts
+// application/json.
+package squaresqlhttp
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/allapospelova/squaresql"
+)
+
+// Entry describes a query in the catalog served by the handler.
+type Entry struct {
+	*squaresql.Query
+	Tags  []string              `json:"tags,omitempty"`
+	Stats *squaresql.QueryStats `json:"stats,omitempty"`
+}
+
+// Catalog is the document served by the handler.
+type Catalog struct {
+	Dialect squaresql.Dialect `json:"dialect,omitempty"`
+	Search  string            `json:"search,omitempty"`
+	Queries []Entry           `json:"queries"`
+}
+
+// Handler returns a handler serving the catalog of square.
+func Handler(square *squaresql.SquareSql) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		catalog := Build(square, r.URL.Query().Get("q"))
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(catalog)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := page.Execute(w, catalog); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Build returns the catalog of square, restricted to queries whose name
+// contains search or that carry a tag containing it.
+func Build(square *squaresql.SquareSql, search string) *Catalog {
+	stats := square.Stats()
+	search = strings.TrimSpace(search)
+
+	catalog := &Catalog{Dialect: square.Dialect(), Search: search, Queries: []Entry{}}
+	for _, q := range square.Queries() {
+		entry := Entry{Query: q, Tags: q.Tags()}
+		if !entry.matches(strings.ToLower(search)) {
+			continue
+		}
+		if qs, ok := stats[q.Name]; ok {
+			entry.Stats = &qs
+		}
+		catalog.Queries = append(catalog.Queries, entry)
+	}
+	sort.SliceStable(catalog.Queries, func(i, j int) bool {
+		return catalog.Queries[i].Name < catalog.Queries[j].Name
+	})
+
+	return catalog
+}
+
+func (e Entry) matches(search string) bool {
+	if len(search) == 0 || strings.Contains(strings.ToLower(e.Name), search) {
+		return true
+	}
+	for _, tag := range e.Tags {
+		if strings.Contains(strings.ToLower(tag), search) {
+			return true
+		}
+	}
+	return false
+}
+
+func wantsJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); len(format) > 0 {
+		return format == "json"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+var page = template.Must(template.New("catalog").Funcs(template.FuncMap{
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Microsecond).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Queries</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: .4em; text-align: left; vertical-align: top; }
+pre { margin: 0; white-space: pre-wrap; }
+.tag { background: #eef; border-radius: 3px; padding: 0 .3em; margin-right: .3em; }
+.error { color: #a00; }
+</style>
+</head>
+<body>
+<h1>Queries{{if .Dialect}} ({{.Dialect}}){{end}}</h1>
+<form method="get">
+<input type="search" name="q" value="{{.Search}}" placeholder="Filter by name or tag">
+<button type="submit">Search</button>
+<a href="?format=json{{if .Search}}&amp;q={{.Search}}{{end}}">JSON</a>
+</form>
+<p>{{len .Queries}} queries</p>
+<table>
+<tr><th>Name</th><th>SQL</th><th>Source</th><th>Calls</th><th>Errors</th><th>Mean</th><th>Max</th></tr>
+{{range .Queries}}
+<tr id="{{.Name}}">
+<td><strong>{{.Name}}</strong>{{if .Dialect}} <em>{{.Dialect}}</em>{{end}}<br>
+{{range .Tags}}<span class="tag">{{.}}</span>{{end}}
+{{range $k, $v := .Annotations}}<br><small>{{$k}}: {{$v}}</small>{{end}}</td>
+<td><pre>{{.SQL}}</pre></td>
+<td>{{if .File}}{{.File}}:{{.Line}}{{end}}<br><small>{{.Fingerprint}}</small></td>
+{{with .Stats}}<td>{{.Calls}}{{if or .CacheHits .Collapsed}}<br><small>{{.CacheHits}} cached, {{.Collapsed}} collapsed</small>{{end}}</td><td>{{.Errors}}{{if .LastError}}<br><small class="error">{{.LastError}}</small>{{end}}</td><td>{{duration .MeanDuration}}</td><td>{{duration .MaxDuration}}</td>
+{{else}}<td>0</td><td>0</td><td></td><td></td>{{end}}
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,104 @@
+package squaresqlhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSquare(t *testing.T) *squaresql.SquareSql {
+	square, err := squaresql.LoadFromString(`
+	-- name: find-user
+	-- tags: users
+	SELECT * FROM users WHERE id = ?
+	-- name: list-products
+	-- tags: catalog, products
+	SELECT * FROM products WHERE name < '<b>'
+	`)
+	assert.NoError(t, err)
+	return square
+}
+
+func TestHandlerJSON(t *testing.T) {
+	square := newSquare(t)
+	db, mock := squaresqltest.New(t, square)
+	mock.ExpectExec("find-user").WillReturnResult(0, 0)
+	mock.ExpectExec("find-user").WillReturnError(errors.New("boom"))
+
+	_, err := square.Exec(db, "find-user", 1)
+	assert.NoError(t, err)
+	_, err = square.Exec(db, "find-user", 1)
+	assert.Error(t, err)
+
+	rec := httptest.NewRecorder()
+	Handler(square).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?format=json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	var catalog Catalog
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &catalog))
+	if assert.Len(t, catalog.Queries, 2) {
+		user := catalog.Queries[0]
+		assert.Equal(t, "find-user", user.Name)
+		assert.Equal(t, []string{"users"}, user.Tags)
+		assert.Equal(t, 2, user.Line)
+		if assert.NotNil(t, user.Stats) {
+			assert.Equal(t, int64(2), user.Stats.Calls)
+			assert.Equal(t, int64(1), user.Stats.Errors)
+			assert.Equal(t, "boom", user.Stats.LastError)
+		}
+		assert.Nil(t, catalog.Queries[1].Stats)
+	}
+}
+
+func TestHandlerSearch(t *testing.T) {
+	square := newSquare(t)
+
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{"", []string{"find-user", "list-products"}},
+		{"USER", []string{"find-user"}},
+		{"catalog", []string{"list-products"}},
+		{"missing", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.search, func(t *testing.T) {
+			var names []string
+			for _, e := range Build(square, tt.search).Queries {
+				names = append(names, e.Name)
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+}
+
+func TestHandlerHTML(t *testing.T) {
+	square := newSquare(t)
+
+	rec := httptest.NewRecorder()
+	Handler(square).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?q=products", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "list-products")
+	assert.Contains(t, rec.Body.String(), "&lt;b&gt;")
+	assert.NotContains(t, rec.Body.String(), "find-user")
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	Handler(square).ServeHTTP(rec, req)
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	rec = httptest.NewRecorder()
+	Handler(square).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,141 @@
+package squaresqltest
+
+import (
+	"regexp"
+	"strings"
+)
+
+// collapse normalises whitespace so that formatting differences do not
+// count as mismatches.
+func collapse(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+var placeholderRe = regexp.MustCompile(`\{\{\s*[A-Za-z_][A-Za-z0-9_]*\s*\}\}`)
+
+// identifierPattern matches an identifier rendered for a placeholder, plain
+// or quoted for any dialect.
+const identifierPattern = "[A-Za-z_][A-Za-z0-9_]*|\"[A-Za-z_][A-Za-z0-9_]*\"|`[A-Za-z_][A-Za-z0-9_]*`|\\[[A-Za-z_][A-Za-z0-9_]*\\]"
+
+// matchQuery reports whether query is the statement template, a query as
+// declared, once rendered: identifier placeholders such as {{schema}} match
+// any identifier, except inside literals and comments where rendering keeps
+// them. Differences in whitespace are ignored.
+func matchQuery(template, query string) bool {
+	if collapse(template) == collapse(query) {
+		return true
+	}
+	if !strings.Contains(template, "{{") {
+		return false
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString(`^\s*`)
+	last := 0
+	for _, loc := range placeholderRe.FindAllStringIndex(template, -1) {
+		pattern.WriteString(quoteSQL(template[last:loc[0]]))
+		if inCode(template[:loc[0]]) {
+			pattern.WriteString("(?:" + identifierPattern + ")")
+		} else {
+			pattern.WriteString(regexp.QuoteMeta(template[loc[0]:loc[1]]))
+		}
+		last = loc[1]
+	}
+	pattern.WriteString(quoteSQL(template[last:]))
+	pattern.WriteString(`\s*$`)
+
+	return regexp.MustCompile(pattern.String()).MatchString(strings.TrimSpace(query))
+}
+
+// quoteSQL quotes text for a regular expression matching it with any run of
+// whitespace in place of its own.
+func quoteSQL(text string) string {
+	return regexp.MustCompile(`\s+`).ReplaceAllString(regexp.QuoteMeta(text), `\s+`)
+}
+
+// inCode reports whether the end of prefix lies outside string literals,
+// quoted identifiers and comments.
+func inCode(prefix string) bool {
+	// end closes the literal or comment being scanned, if any.
+	var end string
+	for i := 0; i < len(prefix); i++ {
+		if len(end) > 0 {
+			if strings.HasPrefix(prefix[i:], end) {
+				i += len(end) - 1
+				end = ""
+			}
+			continue
+		}
+
+		switch {
+		case prefix[i] == '\'' || prefix[i] == '"' || prefix[i] == '`':
+			end = prefix[i : i+1]
+		case strings.HasPrefix(prefix[i:], "--"):
+			end = "\n"
+		case strings.HasPrefix(prefix[i:], "/*"):
+			end = "*/"
+			i++
+		}
+	}
+	return len(end) == 0
+}
+
+func indent(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		lines[i] = "    " + strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// diff returns a line diff of two statements, ignoring indentation.
+func diff(expected, got string) string {
+	a := lines(expected)
+	b := lines(got)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out = append(out, "    "+a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "  - "+a[i])
+			i++
+		default:
+			out = append(out, "  + "+b[j])
+			j++
+		}
+	}
+
+	return strings.Join(out, "\n")
+}
+
+func lines(text string) []string {
+	var out []string
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			out = append(out, line)
+		}
+	}
+	return out
+}
@@ -0,0 +1,228 @@
+package squaresqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// DriverName is the name the fake driver is registered under.
+const DriverName = "squaresqltest"
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*Mock)
+	sequence   int
+)
+
+func init() {
+	sql.Register(DriverName, fakeDriver{})
+}
+
+func register(m *Mock) string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	sequence++
+	dsn := fmt.Sprintf("mock-%d", sequence)
+	registry[dsn] = m
+	return dsn
+}
+
+func unregister(dsn string) {
+	registryMu.Lock()
+	delete(registry, dsn)
+	registryMu.Unlock()
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	registryMu.Lock()
+	m, ok := registry[dsn]
+	registryMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("squaresqltest: unknown data source %q", dsn)
+	}
+
+	return &conn{mock: m}, nil
+}
+
+type conn struct {
+	mock *Mock
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if c.mock.checksPrepares() {
+		if _, err := c.answer(ctx, kindPrepare, query, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	if _, err := c.answer(ctx, kindBegin, "", nil); err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	e, err := c.answer(ctx, kindQuery, query, values(args))
+	if err != nil {
+		return nil, err
+	}
+
+	if e.rows == nil {
+		return &rows{}, nil
+	}
+	if e.rows.err != nil {
+		return nil, e.rows.err
+	}
+	return &rows{columns: e.rows.columns, values: e.rows.values}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, err := c.answer(ctx, kindExec, query, values(args))
+	if err != nil {
+		return nil, err
+	}
+
+	return result{lastInsertID: e.lastInsertID, rowsAffected: e.rowsAffected}, nil
+}
+
+// answer matches a statement against the expectations and waits for the
+// configured delay before handing back the matched expectation.
+func (c *conn) answer(ctx context.Context, k kind, query string, args []driver.Value) (*Expectation, error) {
+	e, err := c.mock.match(k, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.delay > 0 {
+		timer := time.NewTimer(e.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e, nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	vs := make([]driver.Value, len(args))
+	for i, arg := range args {
+		vs[i] = arg.Value
+	}
+	return vs
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), named(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	nvs := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return nvs
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	_, err := t.conn.answer(context.Background(), kindCommit, "", nil)
+	return err
+}
+
+func (t *tx) Rollback() error {
+	_, err := t.conn.answer(context.Background(), kindRollback, "", nil)
+	return err
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+	pos     int
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.pos])
+	r.pos++
+	return nil
+}
+
+type result struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r result) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
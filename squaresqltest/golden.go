@@ -0,0 +1,432 @@
+package squaresqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+)
+
+// Mode selects whether a Golden harness talks to a real database.
+type Mode int
+
+const (
+	// Replay serves results from golden files without a database.
+	Replay Mode = iota
+	// Record runs statements against a real database and stores their
+	// results in golden files.
+	Record
+)
+
+// ModeEnv is the environment variable read by ModeFromEnv.
+const ModeEnv = "SQUARESQL_GOLDEN"
+
+// ModeFromEnv returns Record when the SQUARESQL_GOLDEN environment variable
+// is set to "record", and Replay otherwise.
+func ModeFromEnv() Mode {
+	if strings.EqualFold(os.Getenv(ModeEnv), "record") {
+		return Record
+	}
+	return Replay
+}
+
+// DB is the database wrapped by a Golden harness in Record mode.
+type DB interface {
+	squaresql.QueryerContext
+	squaresql.ExecerContext
+}
+
+// Golden is a record-and-replay harness implementing the squaresql Queryer,
+// QueryRower and Execer interfaces. In Record mode every named query is run
+// against a real database and its arguments and results are stored in a
+// golden file per query name. In Replay mode the same calls are answered from
+// those files, and a call whose SQL text or arguments differ from the
+// recording fails the test.
+type Golden struct {
+	t      testing.TB
+	square *squaresql.SquareSql
+	dir    string
+	mode   Mode
+	db     DB
+
+	replay *sql.DB
+	mock   *Mock
+
+	mu    sync.Mutex
+	files map[string]*goldenFile
+}
+
+// NewGolden returns a harness storing golden files in dir. db is only used,
+// and must only be set, in Record mode.
+func NewGolden(t testing.TB, square *squaresql.SquareSql, dir string, mode Mode, db DB) *Golden {
+	t.Helper()
+
+	if mode == Record && db == nil {
+		t.Fatalf("squaresqltest: recording golden files requires a database")
+	}
+
+	g := &Golden{
+		t:      t,
+		square: square,
+		dir:    dir,
+		mode:   mode,
+		db:     db,
+		files:  make(map[string]*goldenFile),
+	}
+	g.replay, g.mock = New(t, nil)
+	g.mock.InAnyOrder()
+
+	if mode == Record {
+		t.Cleanup(func() {
+			if err := g.write(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	return g
+}
+
+func (g *Golden) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return g.QueryContext(context.Background(), query, args...)
+}
+
+func (g *Golden) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	c, err := g.call(ctx, kindQuery, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.replay.QueryContext(ctx, c.SQL, c.args()...)
+}
+
+func (g *Golden) QueryRow(query string, args ...interface{}) *sql.Row {
+	return g.QueryRowContext(context.Background(), query, args...)
+}
+
+func (g *Golden) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	c, err := g.call(ctx, kindQuery, query, args)
+	if err != nil {
+		g.mock.ExpectQuerySQL(query).WillReturnError(err)
+		return g.replay.QueryRowContext(ctx, query)
+	}
+
+	return g.replay.QueryRowContext(ctx, c.SQL, c.args()...)
+}
+
+func (g *Golden) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return g.ExecContext(context.Background(), query, args...)
+}
+
+func (g *Golden) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	c, err := g.call(ctx, kindExec, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.replay.ExecContext(ctx, c.SQL, c.args()...)
+}
+
+// call records or looks up the golden call for a statement and scripts the
+// fake database to answer it.
+func (g *Golden) call(ctx context.Context, k kind, query string, args []interface{}) (*goldenCall, error) {
+	name, err := g.name(query)
+	if err != nil {
+		return nil, g.fail(err)
+	}
+
+	encoded, err := encodeArgs(args)
+	if err != nil {
+		return nil, g.fail(fmt.Errorf("squaresqltest: arguments of %q: %v", name, err))
+	}
+
+	var c *goldenCall
+	if g.mode == Record {
+		c, err = g.record(ctx, name, k, query, args, encoded)
+	} else {
+		c, err = g.lookup(name, k, query, encoded)
+	}
+	if err != nil {
+		return nil, g.fail(err)
+	}
+
+	e := g.mock.expect(k, name, c.SQL).WithArgs(c.args()...)
+	switch {
+	case len(c.Error) > 0:
+		e.WillReturnError(errors.New(c.Error))
+	case k == kindQuery:
+		rows := &Rows{columns: c.Columns, values: make([][]driver.Value, len(c.Rows))}
+		for i, row := range c.Rows {
+			rows.values[i] = decodeValues(row)
+		}
+		e.WillReturnRows(rows)
+	default:
+		e.WillReturnResult(c.LastInsertID, c.RowsAffected)
+	}
+
+	return c, nil
+}
+
+func (g *Golden) fail(err error) error {
+	g.t.Error(err)
+	return err
+}
+
+// name returns the name of the query whose SQL, once its identifier
+// placeholders are rendered, is query. Queries sharing the same SQL resolve
+// to the first of their sorted names.
+func (g *Golden) name(query string) (string, error) {
+	queries := g.square.QueryMap()
+	names := make([]string, 0, len(queries))
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if matchQuery(queries[name], query) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("squaresqltest: statement is not a named query:\n%s", indent(query))
+}
+
+func (g *Golden) record(ctx context.Context, name string, k kind, query string, args []interface{}, encoded []value) (*goldenCall, error) {
+	c := &goldenCall{Kind: string(k), SQL: query, Args: encoded}
+
+	if k == kindQuery {
+		if err := c.recordRows(g.db.QueryContext(ctx, query, args...)); err != nil {
+			return nil, fmt.Errorf("squaresqltest: recording %q: %v", name, err)
+		}
+	} else {
+		res, err := g.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			c.Error = err.Error()
+		} else {
+			c.LastInsertID, _ = res.LastInsertId()
+			c.RowsAffected, _ = res.RowsAffected()
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f := g.files[name]
+	if f == nil {
+		f = &goldenFile{Query: name}
+		g.files[name] = f
+	}
+	f.Calls = append(f.Calls, c)
+
+	return c, nil
+}
+
+func (g *Golden) lookup(name string, k kind, query string, encoded []value) (*goldenCall, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f, ok := g.files[name]
+	if !ok {
+		var err error
+		if f, err = g.read(name); err != nil {
+			return nil, err
+		}
+		g.files[name] = f
+	}
+
+	if f.next >= len(f.Calls) {
+		return nil, fmt.Errorf("squaresqltest: %q was called %d time(s) while recording, re-record with %s=record", name, len(f.Calls), ModeEnv)
+	}
+	c := f.Calls[f.next]
+	f.next++
+
+	if c.Kind != string(k) {
+		return nil, fmt.Errorf("squaresqltest: call %d of %q was recorded as %s, got %s", f.next, name, c.Kind, k)
+	}
+	if collapse(c.SQL) != collapse(query) {
+		return nil, fmt.Errorf("squaresqltest: SQL of %q drifted from the recording (-recorded +got):\n%s", name, diff(c.SQL, query))
+	}
+	if recorded, got := formatEncoded(c.Args), formatEncoded(encoded); recorded != got {
+		return nil, fmt.Errorf("squaresqltest: arguments of call %d of %q drifted from the recording:\n  recorded: %s\n  got:      %s", f.next, name, recorded, got)
+	}
+
+	return c, nil
+}
+
+// ExpectationsWereMet reports, in Replay mode, the recorded calls that were
+// not replayed, including those of the golden files of the directory no call
+// read. The directory should therefore only hold the recordings of the test.
+func (g *Golden) ExpectationsWereMet() error {
+	if g.mode == Record {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(g.dir, "*.golden.json"))
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	read := make(map[string]bool, len(g.files))
+	var pending []string
+	for name, f := range g.files {
+		read[g.path(name)] = true
+		if f.next < len(f.Calls) {
+			pending = append(pending, fmt.Sprintf("  %q: %d of %d call(s) replayed", name, f.next, len(f.Calls)))
+		}
+	}
+	for _, path := range paths {
+		if read[path] {
+			continue
+		}
+		f, err := readGoldenFile(path)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, fmt.Sprintf("  %q: 0 of %d call(s) replayed", f.Query, len(f.Calls)))
+	}
+	if len(pending) > 0 {
+		sort.Strings(pending)
+		return fmt.Errorf("squaresqltest: recorded calls were not replayed, re-record with %s=record:\n%s", ModeEnv, strings.Join(pending, "\n"))
+	}
+
+	return nil
+}
+
+func (g *Golden) path(name string) string {
+	return filepath.Join(g.dir, strings.Replace(name, string(filepath.Separator), "_", -1)+".golden.json")
+}
+
+func (g *Golden) read(name string) (*goldenFile, error) {
+	f, err := readGoldenFile(g.path(name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("squaresqltest: no golden file for %q, record one with %s=record", name, ModeEnv)
+	}
+	return f, err
+}
+
+func readGoldenFile(path string) (*goldenFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &goldenFile{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("squaresqltest: %s: %v", path, err)
+	}
+	return f, nil
+}
+
+func (g *Golden) write() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := os.MkdirAll(g.dir, 0755); err != nil {
+		return err
+	}
+	for name, f := range g.files {
+		data, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(g.path(name), append(data, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type goldenFile struct {
+	Query string        `json:"query"`
+	Calls []*goldenCall `json:"calls"`
+
+	next int
+}
+
+type goldenCall struct {
+	Kind         string    `json:"kind"`
+	SQL          string    `json:"sql"`
+	Args         []value   `json:"args"`
+	Columns      []string  `json:"columns,omitempty"`
+	Rows         [][]value `json:"rows,omitempty"`
+	LastInsertID int64     `json:"lastInsertId,omitempty"`
+	RowsAffected int64     `json:"rowsAffected,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+func (c *goldenCall) args() []interface{} {
+	args := make([]interface{}, len(c.Args))
+	for i, v := range c.Args {
+		args[i] = v.v
+	}
+	return args
+}
+
+func (c *goldenCall) recordRows(rows *sql.Rows, err error) error {
+	if err != nil {
+		c.Error = err.Error()
+		return nil
+	}
+	defer rows.Close()
+
+	if c.Columns, err = rows.Columns(); err != nil {
+		return err
+	}
+	for rows.Next() {
+		dest := make([]interface{}, len(c.Columns))
+		ptrs := make([]interface{}, len(dest))
+		for i := range dest {
+			ptrs[i] = &dest[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+
+		row, err := encodeArgs(dest)
+		if err != nil {
+			return err
+		}
+		c.Rows = append(c.Rows, row)
+	}
+
+	return rows.Err()
+}
+
+func encodeArgs(args []interface{}) ([]value, error) {
+	values := make([]value, len(args))
+	for i, arg := range args {
+		v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value{v}
+	}
+	return values, nil
+}
+
+func decodeValues(values []value) []driver.Value {
+	row := make([]driver.Value, len(values))
+	for i, v := range values {
+		row[i] = v.v
+	}
+	return row
+}
+
+func formatEncoded(values []value) string {
+	data, _ := json.Marshal(values)
+	return string(data)
+}
@@ -0,0 +1,148 @@
+package squaresqltest
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoldenRecordAndReplay(t *testing.T) {
+	square := loadSquare(t)
+	dir := t.TempDir()
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	// Record against a scripted database standing in for a real one.
+	rec := &recorder{TB: t}
+	db, mock := New(rec, square)
+	mock.ExpectQuery("find-user").WithArgs(1).
+		WillReturnRows(NewRows("id", "name", "created_at", "avatar").AddRow(1, "alice", created, []byte{}))
+	mock.ExpectExec("rename-user").WithArgs("bob", 1).WillReturnResult(0, 1)
+
+	golden := NewGolden(rec, square, dir, Record, db)
+	var name string
+	row, err := square.QueryRow(golden, "find-user", 1)
+	assert.NoError(t, err)
+	var (
+		id      int64
+		at      time.Time
+		avatar  []byte
+		renamed int64
+	)
+	assert.NoError(t, row.Scan(&id, &name, &at, &avatar))
+	res, err := square.Exec(golden, "rename-user", "bob", 1)
+	assert.NoError(t, err)
+	renamed, _ = res.RowsAffected()
+	assert.Equal(t, int64(1), renamed)
+
+	rec.finish()
+	assert.Empty(t, rec.errors)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "find-user.golden.json"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"time": "2021-03-04T05:06:07Z"`)
+
+	t.Run("replay", func(t *testing.T) {
+		golden := NewGolden(t, square, dir, Replay, nil)
+
+		rows, err := square.Query(golden, "find-user", 1)
+		assert.NoError(t, err)
+		assert.True(t, rows.Next())
+		var name string
+		assert.NoError(t, rows.Scan(&id, &name, &at, &avatar))
+		assert.NoError(t, rows.Close())
+		assert.Equal(t, int64(1), id)
+		assert.Equal(t, "alice", name)
+		assert.True(t, at.Equal(created))
+		assert.Equal(t, []byte{}, avatar)
+
+		res, err := square.Exec(golden, "rename-user", "bob", 1)
+		assert.NoError(t, err)
+		affected, _ := res.RowsAffected()
+		assert.Equal(t, int64(1), affected)
+		assert.NoError(t, golden.ExpectationsWereMet())
+	})
+
+	t.Run("calls not replayed", func(t *testing.T) {
+		golden := NewGolden(t, square, dir, Replay, nil)
+
+		row, err := square.QueryRow(golden, "find-user", 1)
+		assert.NoError(t, err)
+		assert.NoError(t, row.Scan(&id, &name, &at, &avatar))
+
+		err = golden.ExpectationsWereMet()
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), `"rename-user": 0 of 1 call(s) replayed`)
+			assert.NotContains(t, err.Error(), "find-user")
+		}
+	})
+
+	t.Run("queries sharing SQL", func(t *testing.T) {
+		shared := loadSquare(t)
+		shared.Add("a-find-user", "SELECT id, name FROM users WHERE id = ?")
+		golden := NewGolden(t, shared, dir, Replay, nil)
+
+		for i := 0; i < 10; i++ {
+			name, err := golden.name("SELECT id, name FROM users WHERE id = ?")
+			assert.NoError(t, err)
+			assert.Equal(t, "a-find-user", name)
+		}
+	})
+
+	t.Run("identifier placeholders", func(t *testing.T) {
+		tenants, err := squaresql.LoadFromString(`
+		-- name: orders
+		SELECT id FROM {{schema}}.orders
+		`, squaresql.WithDialect(squaresql.Postgres))
+		assert.NoError(t, err)
+		tenantA := squaresql.WithIdentifiers(context.Background(), map[string]string{"schema": "tenant_a"})
+		dir := t.TempDir()
+
+		rec := &recorder{TB: t}
+		db, mock := New(rec, tenants)
+		mock.ExpectQuery("orders").WillReturnRows(NewRows("id").AddRow(1))
+
+		golden := NewGolden(rec, tenants, dir, Record, db)
+		rows, err := tenants.QueryContext(tenantA, golden, "orders")
+		if assert.NoError(t, err) {
+			assert.NoError(t, rows.Close())
+		}
+		rec.finish()
+		assert.Empty(t, rec.errors)
+
+		golden = NewGolden(t, tenants, dir, Replay, nil)
+		row, err := tenants.QueryRowContext(tenantA, golden, "orders")
+		assert.NoError(t, err)
+		assert.NoError(t, row.Scan(&id))
+		assert.Equal(t, int64(1), id)
+		assert.NoError(t, golden.ExpectationsWereMet())
+	})
+
+	t.Run("argument drift", func(t *testing.T) {
+		rec := &recorder{TB: t}
+		golden := NewGolden(rec, square, dir, Replay, nil)
+
+		_, err := square.Exec(golden, "rename-user", "carol", 1)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), `arguments of call 1 of "rename-user" drifted`)
+		}
+		rec.finish()
+		assert.NotEmpty(t, rec.errors)
+	})
+
+	t.Run("sql drift", func(t *testing.T) {
+		rec := &recorder{TB: t}
+		changed := loadSquare(t)
+		changed.Add("rename-user", "UPDATE users SET name = ?, updated_at = now() WHERE id = ?")
+		golden := NewGolden(rec, changed, dir, Replay, nil)
+
+		_, err := changed.Exec(golden, "rename-user", "bob", 1)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "+ UPDATE users SET name = ?, updated_at = now() WHERE id = ?")
+		}
+	})
+}
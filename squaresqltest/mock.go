@@ -0,0 +1,435 @@
+// Package squaresqltest provides a fake database/sql driver for testing code
+// built on squaresql. Tests script the statements they expect, either by
+// query name or by SQL text, together with the rows, results or errors the
+// fake database should answer with:
+//
+//	db, mock := squaresqltest.New(t, square)
+//	mock.ExpectQuery("find-user").WithArgs(1).
+//		WillReturnRows(squaresqltest.NewRows("id", "name").AddRow(1, "alice"))
+//
+// Unexpected statements fail with an error describing the difference between
+// the expected and the executed SQL, and expectations that were never met are
+// reported when the test finishes.
+package squaresqltest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allapospelova/squaresql"
+)
+
+type kind string
+
+const (
+	kindQuery    kind = "query"
+	kindExec     kind = "exec"
+	kindBegin    kind = "begin"
+	kindCommit   kind = "commit"
+	kindRollback kind = "rollback"
+	kindPrepare  kind = "prepare"
+)
+
+// Argument matches a single argument value passed to the fake database.
+type Argument interface {
+	Match(v driver.Value) bool
+}
+
+type anyArg struct{}
+
+func (anyArg) Match(driver.Value) bool {
+	return true
+}
+
+func (anyArg) String() string {
+	return "<any>"
+}
+
+// AnyArg returns an Argument matching every value.
+func AnyArg() Argument {
+	return anyArg{}
+}
+
+// Mock holds the expectations of a fake database opened with New.
+type Mock struct {
+	t      testing.TB
+	square *squaresql.SquareSql
+	dsn    string
+
+	mu           sync.Mutex
+	expectations []*Expectation
+	ordered      bool
+	prepares     bool
+	failures     []error
+}
+
+// New opens a fake database whose statements are checked against the
+// expectations scripted on the returned Mock. Query names are resolved with
+// square, which may be nil when only ExpectQuerySQL and ExpectExecSQL are
+// used. Unmet expectations and unexpected statements fail t when the test
+// finishes.
+func New(t testing.TB, square *squaresql.SquareSql) (*sql.DB, *Mock) {
+	t.Helper()
+
+	m := &Mock{t: t, square: square, ordered: true}
+	dsn := register(m)
+	m.dsn = dsn
+
+	db, err := sql.Open(DriverName, dsn)
+	if err != nil {
+		t.Fatalf("squaresqltest: %v", err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+		unregister(dsn)
+		if err := m.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return db, m
+}
+
+// DSN returns the data source name opening the fake database of m with the
+// driver registered as DriverName, for code under test that opens its own
+// database. It is valid until the test finishes.
+func (m *Mock) DSN() string {
+	return m.dsn
+}
+
+// InAnyOrder lets statements match expectations regardless of the order they
+// were scripted in, which is useful when statements are issued concurrently.
+func (m *Mock) InAnyOrder() *Mock {
+	m.mu.Lock()
+	m.ordered = false
+	m.mu.Unlock()
+	return m
+}
+
+// ExpectQuery expects the named query to be run through Query or QueryRow.
+// Its identifier placeholders match the identifiers they were rendered with.
+func (m *Mock) ExpectQuery(name string) *Expectation {
+	m.t.Helper()
+	return m.expect(kindQuery, name, m.resolve(name))
+}
+
+// ExpectQuerySQL expects query to be run through Query or QueryRow.
+func (m *Mock) ExpectQuerySQL(query string) *Expectation {
+	return m.expect(kindQuery, "", query)
+}
+
+// ExpectExec expects the named query to be run through Exec.
+func (m *Mock) ExpectExec(name string) *Expectation {
+	m.t.Helper()
+	return m.expect(kindExec, name, m.resolve(name))
+}
+
+// ExpectExecSQL expects query to be run through Exec.
+func (m *Mock) ExpectExecSQL(query string) *Expectation {
+	return m.expect(kindExec, "", query)
+}
+
+// ExpectPrepare expects the named query to be prepared. Prepared statements
+// are only checked against expectations once the first ExpectPrepare or
+// ExpectPrepareSQL is made; until then, preparing always succeeds.
+func (m *Mock) ExpectPrepare(name string) *Expectation {
+	m.t.Helper()
+	return m.expectPrepare(name, m.resolve(name))
+}
+
+// ExpectPrepareSQL expects query to be prepared.
+func (m *Mock) ExpectPrepareSQL(query string) *Expectation {
+	return m.expectPrepare("", query)
+}
+
+func (m *Mock) expectPrepare(name, query string) *Expectation {
+	m.mu.Lock()
+	m.prepares = true
+	m.mu.Unlock()
+
+	return m.expect(kindPrepare, name, query)
+}
+
+// checksPrepares reports whether prepared statements must be expected.
+func (m *Mock) checksPrepares() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.prepares
+}
+
+// ExpectBegin expects a transaction to be started.
+func (m *Mock) ExpectBegin() *Expectation {
+	return m.expect(kindBegin, "", "")
+}
+
+// ExpectCommit expects a transaction to be committed.
+func (m *Mock) ExpectCommit() *Expectation {
+	return m.expect(kindCommit, "", "")
+}
+
+// ExpectRollback expects a transaction to be rolled back.
+func (m *Mock) ExpectRollback() *Expectation {
+	return m.expect(kindRollback, "", "")
+}
+
+// ExpectationsWereMet returns an error describing the first unexpected
+// statement or the expectations that were not met, if any.
+func (m *Mock) ExpectationsWereMet() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.failures) > 0 {
+		return m.failures[0]
+	}
+
+	var pending []string
+	for _, e := range m.expectations {
+		if !e.done {
+			pending = append(pending, "  "+e.describe())
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("squaresqltest: %d expectation(s) were not met:\n%s", len(pending), strings.Join(pending, "\n"))
+	}
+
+	return nil
+}
+
+func (m *Mock) resolve(name string) string {
+	m.t.Helper()
+
+	if m.square == nil {
+		m.t.Fatalf("squaresqltest: cannot resolve query %q without a SquareSql", name)
+	}
+	query, err := m.square.Raw(name)
+	if err != nil {
+		m.t.Fatalf("squaresqltest: %v", err)
+	}
+	return query
+}
+
+func (m *Mock) expect(k kind, name, query string) *Expectation {
+	e := &Expectation{kind: k, name: name, sql: query}
+
+	m.mu.Lock()
+	m.expectations = append(m.expectations, e)
+	m.mu.Unlock()
+
+	return e
+}
+
+// match finds the expectation satisfied by a statement and marks it done.
+func (m *Mock) match(k kind, query string, args []driver.Value) (*Expectation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var err error
+	for _, e := range m.expectations {
+		if e.done {
+			continue
+		}
+		if err = e.check(k, query, args); err == nil {
+			e.done = true
+			return e, nil
+		}
+		if m.ordered {
+			break
+		}
+	}
+
+	if err == nil || !m.ordered {
+		err = unexpected(k, query, args)
+	}
+	m.failures = append(m.failures, err)
+	return nil, err
+}
+
+func unexpected(k kind, query string, args []driver.Value) error {
+	if len(query) == 0 {
+		return fmt.Errorf("squaresqltest: unexpected %s", k)
+	}
+	return fmt.Errorf("squaresqltest: unexpected %s with args %s:\n%s", k, formatArgs(args), indent(query))
+}
+
+// Expectation describes a statement the fake database expects and how it
+// answers it.
+type Expectation struct {
+	kind kind
+	name string
+	sql  string
+
+	args     []interface{}
+	withArgs bool
+
+	rows         *Rows
+	lastInsertID int64
+	rowsAffected int64
+	err          error
+	delay        time.Duration
+
+	done bool
+}
+
+// WithArgs restricts the expectation to statements executed with args.
+// Values are compared after conversion to driver values; an Argument
+// matches values on its own terms.
+func (e *Expectation) WithArgs(args ...interface{}) *Expectation {
+	e.args = args
+	e.withArgs = true
+	return e
+}
+
+// WillReturnRows sets the rows a query answers with.
+func (e *Expectation) WillReturnRows(rows *Rows) *Expectation {
+	e.rows = rows
+	return e
+}
+
+// WillReturnResult sets the result an exec answers with.
+func (e *Expectation) WillReturnResult(lastInsertID, rowsAffected int64) *Expectation {
+	e.lastInsertID = lastInsertID
+	e.rowsAffected = rowsAffected
+	return e
+}
+
+// WillReturnError makes the statement fail with err.
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// WillDelayFor delays the answer by d, or until the statement's context is
+// done.
+func (e *Expectation) WillDelayFor(d time.Duration) *Expectation {
+	e.delay = d
+	return e
+}
+
+func (e *Expectation) describe() string {
+	switch {
+	case len(e.name) > 0:
+		return fmt.Sprintf("%s %q", e.kind, e.name)
+	case len(e.sql) > 0:
+		return fmt.Sprintf("%s %q", e.kind, collapse(e.sql))
+	}
+	return string(e.kind)
+}
+
+func (e *Expectation) check(k kind, query string, args []driver.Value) error {
+	if k != e.kind {
+		if len(query) > 0 {
+			return fmt.Errorf("squaresqltest: expected %s, got %s:\n%s", e.describe(), k, indent(query))
+		}
+		return fmt.Errorf("squaresqltest: expected %s, got %s", e.describe(), k)
+	}
+
+	if !matchQuery(e.sql, query) {
+		return fmt.Errorf("squaresqltest: %s does not match the executed SQL (-expected +got):\n%s", e.describe(), diff(e.sql, query))
+	}
+
+	if e.withArgs && !matchArgs(e.args, args) {
+		return fmt.Errorf("squaresqltest: arguments of %s do not match:\n  expected: %s\n  got:      %s", e.describe(), formatExpected(e.args), formatArgs(args))
+	}
+
+	return nil
+}
+
+func matchArgs(expected []interface{}, got []driver.Value) bool {
+	if len(expected) != len(got) {
+		return false
+	}
+
+	for i, want := range expected {
+		if matcher, ok := want.(Argument); ok {
+			if !matcher.Match(got[i]) {
+				return false
+			}
+			continue
+		}
+
+		converted, err := driver.DefaultParameterConverter.ConvertValue(want)
+		if err != nil || !equalValues(converted, got[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func equalValues(a, b driver.Value) bool {
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func formatExpected(args []interface{}) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = formatValue(arg)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func formatArgs(args []driver.Value) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = formatValue(arg)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case fmt.Stringer:
+		return v.String()
+	case []byte:
+		return fmt.Sprintf("%q", v)
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// Rows is a canned set of rows returned by a query expectation.
+type Rows struct {
+	columns []string
+	values  [][]driver.Value
+	err     error
+}
+
+// NewRows returns an empty set of rows with the given columns.
+func NewRows(columns ...string) *Rows {
+	return &Rows{columns: columns}
+}
+
+// AddRow appends a row. Values must be convertible to driver values and
+// match the number of columns.
+func (r *Rows) AddRow(values ...interface{}) *Rows {
+	if len(values) != len(r.columns) {
+		r.err = fmt.Errorf("squaresqltest: row %d has %d values, expected %d", len(r.values)+1, len(values), len(r.columns))
+		return r
+	}
+
+	row := make([]driver.Value, len(values))
+	for i, v := range values {
+		converted, err := driver.DefaultParameterConverter.ConvertValue(v)
+		if err != nil {
+			r.err = fmt.Errorf("squaresqltest: column %q of row %d: %v", r.columns[i], len(r.values)+1, err)
+			return r
+		}
+		row[i] = converted
+	}
+	r.values = append(r.values, row)
+
+	return r
+}
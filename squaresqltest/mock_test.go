@@ -0,0 +1,254 @@
+package squaresqltest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder captures the failures reported through testing.TB so that tests
+// can assert on them without failing themselves.
+type recorder struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recorder) Error(args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprint(args...))
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func (r *recorder) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func loadSquare(t *testing.T) *squaresql.SquareSql {
+	square, err := squaresql.LoadFromString(`
+	-- name: find-user
+	SELECT id, name FROM users WHERE id = ?
+	-- name: rename-user
+	UPDATE users SET name = ? WHERE id = ?
+	`)
+	assert.NoError(t, err)
+	return square
+}
+
+func TestQueryAndExec(t *testing.T) {
+	square := loadSquare(t)
+	db, mock := New(t, square)
+
+	mock.ExpectQuery("find-user").WithArgs(1).
+		WillReturnRows(NewRows("id", "name").AddRow(1, "alice"))
+	mock.ExpectExec("rename-user").WithArgs("bob", AnyArg()).
+		WillReturnResult(0, 1)
+
+	var (
+		id   int
+		name string
+	)
+	row, err := square.QueryRow(db, "find-user", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, row.Scan(&id, &name))
+	assert.Equal(t, 1, id)
+	assert.Equal(t, "alice", name)
+
+	res, err := square.Exec(db, "rename-user", "bob", 1)
+	assert.NoError(t, err)
+	affected, err := res.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIdentifierPlaceholders(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: orders
+	SELECT * FROM {{schema}}.orders WHERE note <> '{{schema}}'
+	`, squaresql.WithDialect(squaresql.Postgres))
+	assert.NoError(t, err)
+	tenantA := squaresql.WithIdentifiers(context.Background(), map[string]string{"schema": "tenant_a"})
+
+	t.Run("rendered", func(t *testing.T) {
+		db, mock := New(t, square)
+		mock.ExpectQuery("orders").WillReturnRows(NewRows("id"))
+
+		rows, err := square.QueryContext(tenantA, db, "orders")
+		if assert.NoError(t, err) {
+			assert.NoError(t, rows.Close())
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("literals are not placeholders", func(t *testing.T) {
+		rec := &recorder{TB: t}
+		db, mock := New(rec, square)
+		mock.ExpectQuery("orders")
+
+		_, err := db.Query(`SELECT * FROM "tenant_a".orders WHERE note <> 'tenant_a'`)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), `query "orders" does not match the executed SQL`)
+		}
+
+		rec.finish()
+		assert.Len(t, rec.errors, 1)
+	})
+}
+
+func TestDSN(t *testing.T) {
+	square := loadSquare(t)
+	_, mock := New(t, square)
+	mock.ExpectExec("rename-user").WithArgs("bob", 1).WillReturnResult(0, 1)
+
+	db, err := sql.Open(DriverName, mock.DSN())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = square.Exec(db, "rename-user", "bob", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreparedStatementsAndTransactions(t *testing.T) {
+	square := loadSquare(t)
+	db, mock := New(t, square)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("rename-user").WithArgs("bob", 1).WillReturnResult(0, 1)
+	mock.ExpectCommit()
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	stmt, err := square.PrepareContext(ctx, tx, "rename-user")
+	assert.NoError(t, err)
+	_, err = stmt.ExecContext(ctx, "bob", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.Close())
+	assert.NoError(t, tx.Commit())
+}
+
+func TestExpectPrepare(t *testing.T) {
+	square := loadSquare(t)
+	ctx := context.Background()
+
+	r := &recorder{TB: t}
+	db, mock := New(r, square)
+	failure := errors.New("no such column: name")
+	mock.ExpectPrepare("find-user")
+	mock.ExpectPrepare("rename-user").WillReturnError(failure)
+	mock.ExpectExec("find-user").WillReturnResult(0, 0)
+
+	stmt, err := square.PrepareContext(ctx, db, "find-user")
+	if assert.NoError(t, err) {
+		assert.NoError(t, stmt.Close())
+	}
+	_, err = square.PrepareContext(ctx, db, "rename-user")
+	assert.Equal(t, failure, err)
+
+	// Once prepares are expected, unexpected ones fail.
+	_, err = db.PrepareContext(ctx, "SELECT 1")
+	assert.Error(t, err)
+
+	r.finish()
+	assert.NotEmpty(t, r.errors)
+}
+
+func TestUnexpectedStatements(t *testing.T) {
+	square := loadSquare(t)
+
+	t.Run("different sql", func(t *testing.T) {
+		rec := &recorder{TB: t}
+		db, mock := New(rec, square)
+		mock.ExpectQuery("find-user")
+
+		_, err := db.Query("SELECT id, email FROM users WHERE id = ?", 1)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), `query "find-user" does not match the executed SQL`)
+			assert.Contains(t, err.Error(), "  - SELECT id, name FROM users WHERE id = ?")
+			assert.Contains(t, err.Error(), "  + SELECT id, email FROM users WHERE id = ?")
+		}
+
+		rec.finish()
+		assert.Len(t, rec.errors, 1)
+	})
+
+	t.Run("different arguments", func(t *testing.T) {
+		rec := &recorder{TB: t}
+		db, mock := New(rec, square)
+		mock.ExpectExec("rename-user").WithArgs("bob", 1)
+
+		_, err := square.Exec(db, "rename-user", "bob", 2)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), `expected: ["bob", 1]`)
+			assert.Contains(t, err.Error(), `got:      ["bob", 2]`)
+		}
+	})
+
+	t.Run("wrong order", func(t *testing.T) {
+		rec := &recorder{TB: t}
+		db, mock := New(rec, square)
+		mock.ExpectQuery("find-user")
+		mock.ExpectExec("rename-user")
+
+		_, err := square.Exec(db, "rename-user", "bob", 1)
+		assert.Error(t, err)
+	})
+
+	t.Run("any order", func(t *testing.T) {
+		db, mock := New(t, square)
+		mock.InAnyOrder()
+		mock.ExpectQuery("find-user").WillReturnRows(NewRows("id", "name"))
+		mock.ExpectExec("rename-user")
+
+		_, err := square.Exec(db, "rename-user", "bob", 1)
+		assert.NoError(t, err)
+		rows, err := square.Query(db, "find-user", 1)
+		assert.NoError(t, err)
+		assert.NoError(t, rows.Close())
+	})
+
+	t.Run("unmet expectation", func(t *testing.T) {
+		rec := &recorder{TB: t}
+		_, mock := New(rec, square)
+		mock.ExpectExec("rename-user")
+
+		rec.finish()
+		if assert.Len(t, rec.errors, 1) {
+			assert.Contains(t, rec.errors[0], `exec "rename-user"`)
+		}
+	})
+}
+
+func TestErrorsAndDelays(t *testing.T) {
+	square := loadSquare(t)
+	db, mock := New(t, square)
+
+	failure := errors.New("deadlock detected")
+	mock.ExpectExec("rename-user").WillReturnError(failure)
+	mock.ExpectQuery("find-user").WillDelayFor(time.Second)
+
+	_, err := square.Exec(db, "rename-user", "bob", 1)
+	assert.True(t, errors.Is(err, failure))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = square.QueryContext(ctx, db, "find-user", 1)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
@@ -0,0 +1,84 @@
+package squaresqltest
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// value is a driver value that survives a round trip through JSON with its
+// type intact, e.g. {"int": 1} or {"time": "2021-01-02T15:04:05Z"}.
+type value struct {
+	v driver.Value
+}
+
+type encodedValue struct {
+	Int    *int64   `json:"int,omitempty"`
+	Float  *float64 `json:"float,omitempty"`
+	Bool   *bool    `json:"bool,omitempty"`
+	String *string  `json:"string,omitempty"`
+	Bytes  *[]byte  `json:"bytes,omitempty"`
+	Time   *string  `json:"time,omitempty"`
+}
+
+func (v value) MarshalJSON() ([]byte, error) {
+	var e encodedValue
+	switch x := v.v.(type) {
+	case nil:
+		return []byte("null"), nil
+	case int64:
+		e.Int = &x
+	case float64:
+		e.Float = &x
+	case bool:
+		e.Bool = &x
+	case string:
+		e.String = &x
+	case []byte:
+		if x == nil {
+			x = []byte{}
+		}
+		e.Bytes = &x
+	case time.Time:
+		s := x.UTC().Format(time.RFC3339Nano)
+		e.Time = &s
+	default:
+		return nil, fmt.Errorf("squaresqltest: unsupported value type %T", v.v)
+	}
+	return json.Marshal(e)
+}
+
+func (v *value) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		v.v = nil
+		return nil
+	}
+
+	var e encodedValue
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+
+	switch {
+	case e.Int != nil:
+		v.v = *e.Int
+	case e.Float != nil:
+		v.v = *e.Float
+	case e.Bool != nil:
+		v.v = *e.Bool
+	case e.String != nil:
+		v.v = *e.String
+	case e.Bytes != nil:
+		v.v = *e.Bytes
+	case e.Time != nil:
+		t, err := time.Parse(time.RFC3339Nano, *e.Time)
+		if err != nil {
+			return err
+		}
+		v.v = t
+	default:
+		return fmt.Errorf("squaresqltest: cannot decode value %s", data)
+	}
+	return nil
+}
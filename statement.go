@@ -0,0 +1,61 @@
+package squaresql
+
+import (
+	"strings"
+)
+
+// readStatements are the leading keywords of statements that may be read-only.
+var readStatements = map[string]bool{
+	"select": true, "with": true, "values": true, "table": true,
+	"show": true, "explain": true, "describe": true, "desc": true,
+}
+
+// writeKeywords are keywords that make a statement modify the database, or
+// take locks, wherever they appear in it. REPLACE and ANALYZE only write when
+// they lead a statement, which no read statement starts with; elsewhere they
+// are the replace() function or part of EXPLAIN ANALYZE.
+var writeKeywords = map[string]bool{
+	"insert": true, "update": true, "delete": true, "merge": true,
+	"upsert": true, "into": true, "create": true, "drop": true,
+	"alter": true, "truncate": true, "grant": true, "revoke": true,
+	"lock": true, "call": true,
+}
+
+// IsReadOnly reports whether every statement in sql only reads data, such as
+// a SELECT or a WITH query without data-modifying parts, and may therefore
+// run on a read replica. Statements taking row locks, e.g. with FOR UPDATE or
+// FOR SHARE, are not read-only. Unrecognised statements are assumed to write.
+func IsReadOnly(sql string, dialect Dialect) bool {
+	start := true
+	statements := 0
+	var previous token
+	for _, t := range tokenize(sql, dialect) {
+		if !t.significant() {
+			continue
+		}
+		if t.kind == tokenPunct && t.text == ";" {
+			start = true
+			continue
+		}
+		if t.kind != tokenWord {
+			start = false
+			previous = t
+			continue
+		}
+
+		word := strings.ToLower(t.text)
+		if start {
+			if !readStatements[word] {
+				return false
+			}
+			statements++
+		}
+		if writeKeywords[word] || (word == "share" || word == "key" || word == "no") && previous.is("for") {
+			return false
+		}
+		start = false
+		previous = t
+	}
+
+	return statements > 0
+}
@@ -0,0 +1,40 @@
+package squaresql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReadOnly(t *testing.T) {
+	for _, tt := range []struct {
+		sql      string
+		readOnly bool
+	}{
+		{"SELECT * FROM users WHERE id = ?", true},
+		{"  -- leading comment\n select 1;", true},
+		{"WITH recent AS (SELECT * FROM orders) SELECT count(*) FROM recent", true},
+		{"SELECT 'update' AS word, \"delete\" FROM t", true},
+		{"SHOW TABLES", true},
+		{"SELECT 1; SELECT 2;", true},
+		{"INSERT INTO users (name) VALUES (?)", false},
+		{"update users set name = ?", false},
+		{"WITH moved AS (DELETE FROM a RETURNING *) SELECT * FROM moved", false},
+		{"SELECT * FROM users WHERE id = ? FOR UPDATE", false},
+		{"SELECT * FROM users FOR SHARE", false},
+		{"SELECT * FROM users FOR NO KEY UPDATE", false},
+		{"SELECT * INTO backup FROM users", false},
+		{"EXPLAIN ANALYZE DELETE FROM users", false},
+		{"SELECT 1; DELETE FROM users", false},
+		{"SELECT replace(name, 'a', 'b') FROM users", true},
+		{"EXPLAIN ANALYZE SELECT * FROM users", true},
+		{"REPLACE INTO users (id, name) VALUES (?, ?)", false},
+		{"ANALYZE users", false},
+		{"VACUUM", false},
+		{"", false},
+	} {
+		t.Run(tt.sql, func(t *testing.T) {
+			assert.Equal(t, tt.readOnly, IsReadOnly(tt.sql, Postgres))
+		})
+	}
+}
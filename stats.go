@@ -0,0 +1,140 @@
+package squaresql
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// QueryStats summarises the executions of a named query.
+type QueryStats struct {
+	Calls  int64 `json:"calls"`
+	Errors int64 `json:"errors"`
+	// Retries counts the attempts repeated under the retry policy.
+	Retries int64 `json:"retries"`
+	// CacheHits counts Fetch calls served from the cache and Collapsed
+	// those that shared the result of a concurrent identical call; neither
+	// is included in Calls.
+	CacheHits     int64         `json:"cacheHits"`
+	Collapsed     int64         `json:"collapsed"`
+	TotalDuration time.Duration `json:"totalDuration"`
+	MaxDuration   time.Duration `json:"maxDuration"`
+	LastCalled    time.Time     `json:"lastCalled"`
+	LastError     string        `json:"lastError,omitempty"`
+}
+
+// MeanDuration returns the average duration of a call.
+func (qs QueryStats) MeanDuration() time.Duration {
+	if qs.Calls == 0 {
+		return 0
+	}
+	return qs.TotalDuration / time.Duration(qs.Calls)
+}
+
+// run looks up the named query and calls fn with its text rendered for ctx,
+// retrying it according to the retry policy and recording the call in the
+// execution statistics. Calls on db are not retried when it is a transaction.
+func (s *SquareSql) run(ctx context.Context, db interface{}, name string, fn func(query string) error) error {
+	q, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	policy, err := s.retryPolicyFor(q)
+	if err != nil {
+		return err
+	}
+	if inTx(db) {
+		policy = nil
+	}
+	query, err := s.render(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	retries := 0
+	for attempt := 1; ; attempt++ {
+		err = fn(query)
+		if err == nil || !policy.retry(ctx, attempt, err) {
+			break
+		}
+		retries++
+	}
+	s.record(q.Name, start, retries, err)
+
+	return err
+}
+
+// statsFor returns the statistics of name. It must be called with
+// statsMu held.
+func (s *SquareSql) statsFor(name string) *QueryStats {
+	if s.stats == nil {
+		s.stats = make(map[string]*QueryStats)
+	}
+	qs := s.stats[name]
+	if qs == nil {
+		qs = &QueryStats{}
+		s.stats[name] = qs
+	}
+	return qs
+}
+
+func (s *SquareSql) record(name string, start time.Time, retries int, err error) {
+	elapsed := time.Since(start)
+	root, _ := s.scope()
+
+	root.statsMu.Lock()
+	defer root.statsMu.Unlock()
+
+	qs := root.statsFor(name)
+	qs.Calls++
+	qs.Retries += int64(retries)
+	qs.TotalDuration += elapsed
+	if elapsed > qs.MaxDuration {
+		qs.MaxDuration = elapsed
+	}
+	qs.LastCalled = start
+	if err != nil {
+		qs.Errors++
+		qs.LastError = err.Error()
+	}
+}
+
+func (s *SquareSql) recordCacheHit(name string) {
+	root, _ := s.scope()
+	root.statsMu.Lock()
+	root.statsFor(name).CacheHits++
+	root.statsMu.Unlock()
+}
+
+func (s *SquareSql) recordCollapsed(name string) {
+	root, _ := s.scope()
+	root.statsMu.Lock()
+	root.statsFor(name).Collapsed++
+	root.statsMu.Unlock()
+}
+
+// Stats returns a copy of the execution statistics of every query that was
+// run through s, keyed by name.
+func (s *SquareSql) Stats() map[string]QueryStats {
+	root, prefix := s.scope()
+	root.statsMu.Lock()
+	defer root.statsMu.Unlock()
+
+	stats := make(map[string]QueryStats, len(root.stats))
+	for name, qs := range root.stats {
+		if strings.HasPrefix(name, prefix) {
+			stats[strings.TrimPrefix(name, prefix)] = *qs
+		}
+	}
+
+	return stats
+}
+
+// ResetStats discards all execution statistics.
+func (s *SquareSql) ResetStats() {
+	root, _ := s.scope()
+	root.statsMu.Lock()
+	root.stats = nil
+	root.statsMu.Unlock()
+}
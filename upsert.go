@@ -0,0 +1,241 @@
+package squaresql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// insertStatement is a plain INSERT INTO table (columns) VALUES ... or
+// INSERT INTO table (columns) SELECT ... statement split into its parts.
+type insertStatement struct {
+	// head is the text up to and including the column list.
+	head    string
+	table   string
+	columns []string
+	// body is the VALUES list or SELECT supplying the rows.
+	body string
+	// returning is a trailing RETURNING clause, if any.
+	returning string
+}
+
+// parseInsert splits query, which must be a single INSERT statement with a
+// column list and no conflict clause.
+func parseInsert(query string, dialect Dialect) (*insertStatement, error) {
+	tokens := tokenize(query, dialect)
+	next := func(i int) int {
+		for ; i < len(tokens) && !tokens[i].significant(); i++ {
+		}
+		return i
+	}
+
+	i := next(0)
+	if i >= len(tokens) || !tokens[i].is("insert") {
+		return nil, fmt.Errorf("not an INSERT statement")
+	}
+	if i = next(i + 1); i >= len(tokens) || !tokens[i].is("into") {
+		return nil, fmt.Errorf("INSERT is not followed by INTO")
+	}
+
+	var table strings.Builder
+	for i = next(i + 1); i < len(tokens) && tokens[i].text != "("; i = next(i + 1) {
+		if !isIdent(tokens[i]) && tokens[i].text != "." {
+			return nil, fmt.Errorf("no column list after the table name")
+		}
+		table.WriteString(tokens[i].text)
+	}
+	if table.Len() == 0 || i >= len(tokens) {
+		return nil, fmt.Errorf("no column list after the table name")
+	}
+
+	stmt := &insertStatement{table: table.String()}
+	for i = next(i + 1); i < len(tokens) && tokens[i].text != ")"; i = next(i + 1) {
+		if tokens[i].text == "," {
+			continue
+		}
+		if !isIdent(tokens[i]) {
+			return nil, fmt.Errorf("unexpected %s in the column list", tokens[i].text)
+		}
+		stmt.columns = append(stmt.columns, tokens[i].text)
+	}
+	if i >= len(tokens) || len(stmt.columns) == 0 {
+		return nil, fmt.Errorf("unterminated column list")
+	}
+
+	var head strings.Builder
+	for _, t := range tokens[:i+1] {
+		head.WriteString(t.text)
+	}
+	stmt.head = head.String()
+
+	var body, returning strings.Builder
+	depth := 0
+	for j, t := range tokens[i+1:] {
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth == 0 && t.text == ";":
+			if k := next(i + 2 + j); k < len(tokens) {
+				return nil, fmt.Errorf("more than one statement")
+			}
+			continue
+		case depth == 0 && t.is("on"):
+			if k := next(i + 2 + j); k < len(tokens) && (tokens[k].is("conflict") || tokens[k].is("duplicate")) {
+				return nil, fmt.Errorf("the statement already has a conflict clause")
+			}
+		case depth == 0 && t.is("returning"):
+			returning.WriteString(" ")
+		}
+		if returning.Len() > 0 {
+			returning.WriteString(t.text)
+		} else {
+			body.WriteString(t.text)
+		}
+	}
+	stmt.body = strings.TrimSpace(body.String())
+	stmt.returning = strings.TrimRight(returning.String(), " \t\r\n")
+	if len(stmt.body) == 0 {
+		return nil, fmt.Errorf("no VALUES or SELECT supplying the rows")
+	}
+
+	return stmt, nil
+}
+
+// column returns the column of the statement named name, ignoring quotes and
+// case.
+func (stmt *insertStatement) column(name string) (string, bool) {
+	for _, c := range stmt.columns {
+		if strings.EqualFold(strings.Trim(c, "\"`[]"), strings.Trim(name, "\"`[]")) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// GenerateUpsert turns insert, a plain INSERT statement with a column list,
+// into a statement that updates the existing row when one with the same keys
+// exists: INSERT ... ON CONFLICT on PostgreSQL and SQLite, INSERT ... ON
+// DUPLICATE KEY UPDATE on MySQL and MERGE on SQL Server. The columns in
+// update are overwritten with the inserted values; when update is empty,
+// every inserted column but the keys is. On MySQL the first key is also
+// assigned LAST_INSERT_ID(key), so that LastInsertId reports the updated
+// row's key rather than 0; it should be the AUTO_INCREMENT column.
+func GenerateUpsert(insert string, dialect Dialect, keys, update []string) (string, error) {
+	if len(keys) == 0 {
+		return "", fmt.Errorf("no key columns")
+	}
+	stmt, err := parseInsert(insert, dialect)
+	if err != nil {
+		return "", err
+	}
+
+	var keyColumns, updateColumns []string
+	for _, key := range keys {
+		c, ok := stmt.column(key)
+		if !ok {
+			return "", fmt.Errorf("key %s is not an inserted column", key)
+		}
+		keyColumns = append(keyColumns, c)
+	}
+	if len(update) == 0 {
+	columns:
+		for _, c := range stmt.columns {
+			for _, key := range keyColumns {
+				if c == key {
+					continue columns
+				}
+			}
+			updateColumns = append(updateColumns, c)
+		}
+	}
+	for _, name := range update {
+		c, ok := stmt.column(name)
+		if !ok {
+			return "", fmt.Errorf("update column %s is not an inserted column", name)
+		}
+		updateColumns = append(updateColumns, c)
+	}
+
+	assign := func(format string) string {
+		set := make([]string, len(updateColumns))
+		for i, c := range updateColumns {
+			set[i] = fmt.Sprintf(format, c, c)
+		}
+		return strings.Join(set, ", ")
+	}
+
+	switch dialect {
+	case Postgres, SQLite:
+		action := "DO NOTHING"
+		if len(updateColumns) > 0 {
+			action = "DO UPDATE SET " + assign("%s = EXCLUDED.%s")
+		}
+		return fmt.Sprintf("%s %s ON CONFLICT (%s) %s%s", stmt.head, stmt.body, strings.Join(keyColumns, ", "), action, stmt.returning), nil
+
+	case MySQL:
+		if len(stmt.returning) > 0 {
+			return "", fmt.Errorf("RETURNING is not supported by %s", dialect)
+		}
+		set := fmt.Sprintf("%s = LAST_INSERT_ID(%s)", keyColumns[0], keyColumns[0])
+		if len(updateColumns) > 0 {
+			set += ", " + assign("%s = VALUES(%s)")
+		}
+		return fmt.Sprintf("%s %s ON DUPLICATE KEY UPDATE %s", stmt.head, stmt.body, set), nil
+
+	case SQLServer:
+		if len(stmt.returning) > 0 {
+			return "", fmt.Errorf("RETURNING is not supported by %s", dialect)
+		}
+		on := make([]string, len(keyColumns))
+		for i, c := range keyColumns {
+			on[i] = fmt.Sprintf("target.%s = source.%s", c, c)
+		}
+		source := make([]string, len(stmt.columns))
+		for i, c := range stmt.columns {
+			source[i] = "source." + c
+		}
+		columns := strings.Join(stmt.columns, ", ")
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "MERGE INTO %s AS target USING (%s) AS source (%s) ON %s", stmt.table, stmt.body, columns, strings.Join(on, " AND "))
+		if len(updateColumns) > 0 {
+			fmt.Fprintf(&b, " WHEN MATCHED THEN UPDATE SET %s", assign("%s = source.%s"))
+		}
+		fmt.Fprintf(&b, " WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);", columns, strings.Join(source, ", "))
+		return b.String(), nil
+	}
+
+	return "", fmt.Errorf("upserts require a dialect")
+}
+
+// upsert generates the statement run for a query annotated with
+//
+//	-- upsert: key=id update=name,email
+//
+// where key lists the columns identifying a row and the optional update the
+// columns to overwrite.
+func (q *Query) upsert(dialect Dialect) (string, error) {
+	var keys, update []string
+	for _, field := range strings.Fields(q.Annotation("upsert")) {
+		eq := strings.IndexByte(field, '=')
+		if eq < 0 {
+			return "", fmt.Errorf("squaresql: invalid upsert annotation %q on '%s'", q.Annotation("upsert"), q.Name)
+		}
+		columns := strings.FieldsFunc(field[eq+1:], func(r rune) bool { return r == ',' })
+		switch strings.ToLower(field[:eq]) {
+		case "key":
+			keys = append(keys, columns...)
+		case "update":
+			update = append(update, columns...)
+		default:
+			return "", fmt.Errorf("squaresql: invalid upsert annotation %q on '%s'", q.Annotation("upsert"), q.Name)
+		}
+	}
+
+	upsert, err := GenerateUpsert(q.SQL, dialect, keys, update)
+	if err != nil {
+		return "", fmt.Errorf("squaresql: generating upsert '%s': %v", q.Name, err)
+	}
+	return upsert, nil
+}
@@ -0,0 +1,126 @@
+package squaresql_test
+
+import (
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateUpsert(t *testing.T) {
+	insert := "INSERT INTO users (id, name, email) VALUES (?, ?, ?)"
+
+	tests := []struct {
+		name    string
+		insert  string
+		dialect squaresql.Dialect
+		keys    []string
+		update  []string
+		want    string
+		err     string
+	}{
+		{
+			name:    "postgres",
+			insert:  "INSERT INTO users (id, name, email) VALUES ($1, $2, $3);",
+			dialect: squaresql.Postgres,
+			keys:    []string{"id"},
+			want:    "INSERT INTO users (id, name, email) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, email = EXCLUDED.email",
+		},
+		{
+			name:    "sqlite keeps returning last",
+			insert:  insert + " RETURNING id",
+			dialect: squaresql.SQLite,
+			keys:    []string{"id"},
+			update:  []string{"email"},
+			want:    "INSERT INTO users (id, name, email) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET email = EXCLUDED.email RETURNING id",
+		},
+		{
+			name:    "nothing to update",
+			insert:  "INSERT INTO tags (post_id, tag) VALUES (?, ?)",
+			dialect: squaresql.Postgres,
+			keys:    []string{"post_id", "tag"},
+			want:    "INSERT INTO tags (post_id, tag) VALUES (?, ?) ON CONFLICT (post_id, tag) DO NOTHING",
+		},
+		{
+			name:    "mysql",
+			insert:  insert,
+			dialect: squaresql.MySQL,
+			keys:    []string{"id"},
+			want:    "INSERT INTO users (id, name, email) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), name = VALUES(name), email = VALUES(email)",
+		},
+		{
+			name:    "sqlserver",
+			insert:  "INSERT INTO [users] ([id], [name]) VALUES (@id, @name)",
+			dialect: squaresql.SQLServer,
+			keys:    []string{"id"},
+			want:    "MERGE INTO [users] AS target USING (VALUES (@id, @name)) AS source ([id], [name]) ON target.[id] = source.[id] WHEN MATCHED THEN UPDATE SET [name] = source.[name] WHEN NOT MATCHED THEN INSERT ([id], [name]) VALUES (source.[id], source.[name]);",
+		},
+		{
+			name:   "no dialect",
+			insert: insert,
+			keys:   []string{"id"},
+			err:    "upserts require a dialect",
+		},
+		{
+			name:    "unknown key",
+			insert:  insert,
+			dialect: squaresql.Postgres,
+			keys:    []string{"uuid"},
+			err:     "key uuid is not an inserted column",
+		},
+		{
+			name:    "not an insert",
+			insert:  "UPDATE users SET name = ?",
+			dialect: squaresql.Postgres,
+			keys:    []string{"id"},
+			err:     "not an INSERT statement",
+		},
+		{
+			name:    "existing conflict clause",
+			insert:  insert + " ON CONFLICT DO NOTHING",
+			dialect: squaresql.Postgres,
+			keys:    []string{"id"},
+			err:     "the statement already has a conflict clause",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := squaresql.GenerateUpsert(tt.insert, tt.dialect, tt.keys, tt.update)
+			if len(tt.err) > 0 {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUpsertAnnotation(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: save-user
+	-- upsert: key=id update=name
+	INSERT INTO users (id, name, email) VALUES (?, ?, ?)
+
+	-- name: broken
+	-- upsert: id
+	INSERT INTO users (id) VALUES (?)
+	`)
+	assert.NoError(t, err)
+
+	_, err = square.Raw("save-user")
+	assert.EqualError(t, err, "squaresql: generating upsert 'save-user': upserts require a dialect")
+
+	square.SetDialect(squaresql.MySQL)
+	query, err := square.Raw("save-user")
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO users (id, name, email) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), name = VALUES(name)", query)
+
+	q, err := square.Lookup("save-user")
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO users (id, name, email) VALUES (?, ?, ?)", q.SQL, "the declared SQL is kept")
+
+	_, err = square.Raw("broken")
+	assert.EqualError(t, err, `squaresql: invalid upsert annotation "id" on 'broken'`)
+}
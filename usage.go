@@ -0,0 +1,469 @@
+package squaresql
+
+import (
+	"sort"
+	"strings"
+)
+
+// Usage lists the tables and columns a statement reads and writes.
+type Usage struct {
+	Reads  []TableUsage `json:"reads,omitempty"`
+	Writes []TableUsage `json:"writes,omitempty"`
+}
+
+// TableUsage is a table together with the sorted columns used of it. A
+// column of "*" stands for all columns.
+type TableUsage struct {
+	Table   string   `json:"table"`
+	Columns []string `json:"columns,omitempty"`
+}
+
+// Uses reports whether u reads or writes table, and column of it unless
+// column is empty. Names are compared ignoring case, and a table name
+// without schema matches schema-qualified names.
+func (u *Usage) Uses(table, column string) bool {
+	return usesTable(u.Reads, table, column) || usesTable(u.Writes, table, column)
+}
+
+func usesTable(tables []TableUsage, table, column string) bool {
+	for _, t := range tables {
+		if !sameTable(t.Table, table) {
+			continue
+		}
+		if len(column) == 0 {
+			return true
+		}
+		for _, c := range t.Columns {
+			if c == "*" || strings.EqualFold(c, column) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sameTable(name, table string) bool {
+	if strings.EqualFold(name, table) {
+		return true
+	}
+	i := strings.LastIndex(name, ".")
+	return !strings.Contains(table, ".") && i >= 0 && strings.EqualFold(name[i+1:], table)
+}
+
+// Usage analyses every query, resolved for the configured dialect, and
+// returns its usage keyed by query name. The statement analysed is the one
+// run, such as the upsert generated for an upsert annotation. Identifier
+// placeholders are read as their names, so {{schema}}.orders uses the table
+// schema.orders.
+func (s *SquareSql) Usage() map[string]*Usage {
+	sets, dialect := s.snapshot()
+
+	usage := make(map[string]*Usage, len(sets))
+	for name, set := range sets {
+		if q, ok := set.resolve(dialect); ok {
+			query := replacePlaceholders(q.text(), dialect, func(name string) string { return name })
+			usage[name] = AnalyzeUsage(query, dialect)
+		}
+	}
+	return usage
+}
+
+// QueriesUsing returns the sorted names of the queries reading or writing
+// table, and column of it unless column is empty.
+func (s *SquareSql) QueriesUsing(table, column string) []string {
+	var names []string
+	for name, u := range s.Usage() {
+		if u.Uses(table, column) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sqlKeywords are words that are never taken for column names.
+var sqlKeywords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		all alter and any array as asc between by case cast check collate
+		column conflict constraint create cross current_date current_time
+		current_timestamp default delete desc distinct do drop duplicate else
+		end except exists false fetch filter first for from full group having
+		if ilike in inner insert intersect interval into is join key last
+		lateral left like limit matched merge natural not nothing null nulls offset on
+		only or order outer over partition primary recursive references
+		replace returning right row rows select set similar some table then
+		to true union unique unknown update using values when where window
+		with within`) {
+		sqlKeywords[word] = true
+	}
+}
+
+// clauseKeywords end the assignments of a SET clause.
+var clauseKeywords = map[string]bool{
+	"where": true, "from": true, "returning": true, "when": true,
+	"limit": true, "order": true, "output": true,
+}
+
+// usageAnalyzer extracts the tables and columns used by the significant
+// tokens of a single statement.
+type usageAnalyzer struct {
+	tokens []token
+	// used marks tokens already accounted for as table names, aliases or
+	// written columns.
+	used    []bool
+	ctes    map[string]bool
+	aliases map[string]string
+	tables  []string
+	reads   map[string]map[string]bool
+	writes  map[string]map[string]bool
+}
+
+// AnalyzeUsage extracts the tables and columns the statements in sql read
+// and write. The analysis is lexical: it recognises the table references of
+// SELECT, INSERT, UPDATE, DELETE and MERGE statements, skips common table
+// expressions, and attributes qualified columns to the table or alias they
+// are qualified with, and unqualified columns to the only table of the
+// statement, if it has exactly one.
+func AnalyzeUsage(sql string, dialect Dialect) *Usage {
+	reads := make(map[string]map[string]bool)
+	writes := make(map[string]map[string]bool)
+
+	var statement []token
+	flush := func() {
+		if len(statement) > 0 {
+			a := &usageAnalyzer{
+				tokens:  statement,
+				used:    make([]bool, len(statement)),
+				ctes:    make(map[string]bool),
+				aliases: make(map[string]string),
+				reads:   reads,
+				writes:  writes,
+			}
+			a.analyze()
+		}
+		statement = nil
+	}
+	for _, t := range tokenize(sql, dialect) {
+		switch {
+		case !t.significant():
+		case t.kind == tokenPunct && t.text == ";":
+			flush()
+		default:
+			statement = append(statement, t)
+		}
+	}
+	flush()
+
+	return &Usage{Reads: tableUsages(reads), Writes: tableUsages(writes)}
+}
+
+func tableUsages(tables map[string]map[string]bool) []TableUsage {
+	var usages []TableUsage
+	for table, columns := range tables {
+		tu := TableUsage{Table: table}
+		for column := range columns {
+			tu.Columns = append(tu.Columns, column)
+		}
+		sort.Strings(tu.Columns)
+		usages = append(usages, tu)
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Table < usages[j].Table })
+	return usages
+}
+
+func (a *usageAnalyzer) analyze() {
+	a.findCTEs()
+
+	var target string
+	for i := 0; i < len(a.tokens); i++ {
+		t := a.tokens[i]
+		switch {
+		case t.is("from"):
+			write := a.previous(i).is("delete")
+			for next := i + 1; ; {
+				table, end := a.tableRef(next, write)
+				if write && len(table) > 0 {
+					target = table
+				}
+				if end >= len(a.tokens) || a.tokens[end].text != "," {
+					break
+				}
+				next = end + 1
+			}
+		case t.is("join") || t.is("using") && (!a.next(i, "(") || a.tokens[0].is("merge")):
+			// USING names the source of a MERGE, or the join columns of
+			// a JOIN when followed by a parenthesis.
+			a.tableRef(i+1, false)
+		case t.is("into"):
+			if table, end := a.tableRef(i+1, true); len(table) > 0 {
+				target = table
+				a.columnList(end, table)
+			}
+		case t.is("insert") && a.previous(i).is("then"):
+			// MERGE inserts into the target.
+			if len(target) > 0 {
+				a.columnList(i+1, target)
+			}
+		case t.is("update"):
+			prev := a.previous(i)
+			switch {
+			case prev.is("for") || prev.is("on") || prev.is("key") && a.previous(i-1).is("no"):
+				// FOR UPDATE, FOR NO KEY UPDATE and ON UPDATE actions.
+			case prev.is("key"):
+				// ON DUPLICATE KEY UPDATE assigns columns of the target.
+				if len(target) > 0 {
+					a.assignments(i+1, target)
+				}
+			case prev.is("do") || prev.is("then"):
+				// ON CONFLICT DO UPDATE and MERGE update the target.
+			default:
+				if table, _ := a.tableRef(i+1, true); len(table) > 0 {
+					target = table
+				}
+			}
+		case t.is("set"):
+			if len(target) > 0 {
+				a.assignments(i+1, target)
+			}
+		}
+	}
+
+	a.columns()
+}
+
+func (a *usageAnalyzer) previous(i int) token {
+	if i > 0 {
+		return a.tokens[i-1]
+	}
+	return token{}
+}
+
+func (a *usageAnalyzer) next(i int, text string) bool {
+	return i+1 < len(a.tokens) && a.tokens[i+1].text == text
+}
+
+// findCTEs records the names of common table expressions, so that
+// references to them are not taken for tables.
+func (a *usageAnalyzer) findCTEs() {
+	for i := 0; i+2 < len(a.tokens); i++ {
+		prev := a.previous(i)
+		if !(prev.is("with") || prev.is("recursive") || prev.text == ",") || !isIdent(a.tokens[i]) {
+			continue
+		}
+		j := i + 1
+		if a.tokens[j].text == "(" {
+			j = a.skipParens(j)
+		}
+		if j+1 < len(a.tokens) && a.tokens[j].is("as") && a.tokens[j+1].text == "(" {
+			a.ctes[strings.ToLower(identName(a.tokens[i]))] = true
+			a.used[i] = true
+			for k := i + 1; k < j; k++ {
+				a.used[k] = true
+			}
+		}
+	}
+}
+
+// skipParens returns the index following the parenthesis closing the one
+// at i.
+func (a *usageAnalyzer) skipParens(i int) int {
+	depth := 0
+	for ; i < len(a.tokens); i++ {
+		switch a.tokens[i].text {
+		case "(":
+			depth++
+		case ")":
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+func isIdent(t token) bool {
+	return t.kind == tokenQuotedIdent || t.kind == tokenWord && !sqlKeywords[strings.ToLower(t.text)]
+}
+
+// identName returns the name of an identifier without its quotes.
+func identName(t token) string {
+	if t.kind == tokenQuotedIdent && len(t.text) >= 2 {
+		return t.text[1 : len(t.text)-1]
+	}
+	return t.text
+}
+
+// qualifiedName reads a possibly qualified name starting at i, returning it
+// together with the index following it.
+func (a *usageAnalyzer) qualifiedName(i int) (string, int) {
+	var parts []string
+	for i < len(a.tokens) && isIdent(a.tokens[i]) {
+		parts = append(parts, identName(a.tokens[i]))
+		a.used[i] = true
+		if i+2 < len(a.tokens) && a.tokens[i+1].text == "." && isIdent(a.tokens[i+2]) {
+			a.used[i+1] = true
+			i += 2
+			continue
+		}
+		i++
+		break
+	}
+	return strings.Join(parts, "."), i
+}
+
+// tableRef reads a table reference with an optional alias starting at i and
+// records the table. It returns the table, or an empty string for a
+// subquery or common table expression, and the index following the
+// reference.
+func (a *usageAnalyzer) tableRef(i int, write bool) (string, int) {
+	if i < len(a.tokens) && a.tokens[i].text == "(" {
+		end := a.alias(a.skipParens(i), "")
+		// The column aliases of a derived table are not columns of a table.
+		if end < len(a.tokens) && a.tokens[end].text == "(" {
+			next := a.skipParens(end)
+			for j := end; j < next; j++ {
+				a.used[j] = true
+			}
+			end = next
+		}
+		return "", end
+	}
+
+	table, end := a.qualifiedName(i)
+	if len(table) == 0 {
+		return "", end
+	}
+	if a.ctes[strings.ToLower(table)] {
+		return "", a.alias(end, "")
+	}
+
+	a.tables = append(a.tables, table)
+	a.aliases[strings.ToLower(table)] = table
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		a.aliases[strings.ToLower(table[i+1:])] = table
+	}
+	if write {
+		a.use(a.writes, table, "")
+	} else {
+		a.use(a.reads, table, "")
+	}
+	return table, a.alias(end, table)
+}
+
+// alias reads an optional alias of table at i, returning the index
+// following it.
+func (a *usageAnalyzer) alias(i int, table string) int {
+	if i < len(a.tokens) && a.tokens[i].is("as") {
+		a.used[i] = true
+		i++
+	}
+	if i < len(a.tokens) && isIdent(a.tokens[i]) && !a.next(i, ".") {
+		if len(table) > 0 {
+			a.aliases[strings.ToLower(identName(a.tokens[i]))] = table
+		}
+		a.used[i] = true
+		i++
+	}
+	return i
+}
+
+// columnList records the columns of an INSERT column list at i as written.
+func (a *usageAnalyzer) columnList(i int, table string) {
+	if i >= len(a.tokens) || a.tokens[i].text != "(" {
+		return
+	}
+	end := a.skipParens(i)
+	for j := i + 1; j < end-1; j++ {
+		if isIdent(a.tokens[j]) {
+			a.use(a.writes, table, identName(a.tokens[j]))
+			a.used[j] = true
+		}
+	}
+}
+
+// assignments records the columns assigned by the SET clause at i as
+// written.
+func (a *usageAnalyzer) assignments(i int, table string) {
+	depth := 0
+	expectColumn := true
+	for ; i < len(a.tokens); i++ {
+		t := a.tokens[i]
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			if depth--; depth < 0 {
+				return
+			}
+		case depth > 0:
+		case t.text == ",":
+			expectColumn = true
+			continue
+		case clauseKeywords[strings.ToLower(t.text)] && t.kind == tokenWord:
+			return
+		case expectColumn && isIdent(t):
+			// The column may be qualified with the table.
+			j := i
+			for j+2 < len(a.tokens) && a.tokens[j+1].text == "." {
+				a.used[j] = true
+				a.used[j+1] = true
+				j += 2
+			}
+			a.use(a.writes, table, identName(a.tokens[j]))
+			a.used[j] = true
+			i = j
+		}
+		expectColumn = false
+	}
+}
+
+// columns attributes the remaining identifiers to the tables they are read
+// from.
+func (a *usageAnalyzer) columns() {
+	only := ""
+	if len(a.tables) == 1 {
+		only = a.tables[0]
+	}
+
+	for i := 0; i < len(a.tokens); i++ {
+		t := a.tokens[i]
+		if a.used[i] {
+			continue
+		}
+
+		// A qualified column, or all columns of a table.
+		if isIdent(t) && a.next(i, ".") && i+2 < len(a.tokens) {
+			col := a.tokens[i+2]
+			table, known := a.aliases[strings.ToLower(identName(t))]
+			switch {
+			case !known:
+			case col.text == "*":
+				a.use(a.reads, table, "*")
+			case isIdent(col):
+				a.use(a.reads, table, identName(col))
+			}
+			i += 2
+			continue
+		}
+
+		prev := a.previous(i)
+		switch {
+		case len(only) == 0:
+		case t.text == "*" && (prev.is("select") || prev.is("distinct") || prev.text == ","):
+			a.use(a.reads, only, "*")
+		case isIdent(t) && !a.next(i, "(") && !prev.is("as") && prev.text != "." && prev.text != "::":
+			a.use(a.reads, only, identName(t))
+		}
+	}
+}
+
+func (a *usageAnalyzer) use(tables map[string]map[string]bool, table, column string) {
+	if tables[table] == nil {
+		tables[table] = make(map[string]bool)
+	}
+	if len(column) > 0 {
+		tables[table][column] = true
+	}
+}
@@ -0,0 +1,159 @@
+package squaresql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeUsage(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		sql     string
+		dialect Dialect
+		usage   *Usage
+	}{
+		{
+			name: "select",
+			sql:  "SELECT id, name FROM users WHERE email = ? ORDER BY created_at",
+			usage: &Usage{Reads: []TableUsage{
+				{Table: "users", Columns: []string{"created_at", "email", "id", "name"}},
+			}},
+		},
+		{
+			name: "star",
+			sql:  "SELECT * FROM users",
+			usage: &Usage{Reads: []TableUsage{
+				{Table: "users", Columns: []string{"*"}},
+			}},
+		},
+		{
+			name: "joins and aliases",
+			sql: `SELECT u.name, count(o.id) AS orders
+			FROM public.users AS u
+			LEFT JOIN orders o ON o.user_id = u.id
+			WHERE u.active = true
+			GROUP BY u.name`,
+			usage: &Usage{Reads: []TableUsage{
+				{Table: "orders", Columns: []string{"id", "user_id"}},
+				{Table: "public.users", Columns: []string{"active", "id", "name"}},
+			}},
+		},
+		{
+			name: "common table expressions",
+			sql: `WITH recent (id) AS (SELECT id FROM orders WHERE placed_at > ?)
+			SELECT c.name FROM customers c JOIN recent r ON r.id = c.last_order_id`,
+			usage: &Usage{Reads: []TableUsage{
+				{Table: "customers", Columns: []string{"last_order_id", "name"}},
+				{Table: "orders"},
+			}},
+		},
+		{
+			name: "insert",
+			sql:  "INSERT INTO users (name, email) VALUES (?, ?) RETURNING id",
+			usage: &Usage{
+				Reads:  []TableUsage{{Table: "users", Columns: []string{"id"}}},
+				Writes: []TableUsage{{Table: "users", Columns: []string{"email", "name"}}},
+			},
+		},
+		{
+			name:    "insert on conflict",
+			sql:     `INSERT INTO "users" ("name", email) VALUES ($1, $2) ON CONFLICT (email) DO UPDATE SET name = excluded.name, seen = NULL`,
+			dialect: Postgres,
+			usage: &Usage{
+				Reads:  []TableUsage{{Table: "users", Columns: []string{"email"}}},
+				Writes: []TableUsage{{Table: "users", Columns: []string{"email", "name", "seen"}}},
+			},
+		},
+		{
+			name:    "insert on duplicate key",
+			sql:     "INSERT INTO counters (id, hits) VALUES (?, 1) ON DUPLICATE KEY UPDATE hits = hits + 1",
+			dialect: MySQL,
+			usage: &Usage{
+				Reads:  []TableUsage{{Table: "counters", Columns: []string{"hits"}}},
+				Writes: []TableUsage{{Table: "counters", Columns: []string{"hits", "id"}}},
+			},
+		},
+		{
+			name:    "merge",
+			sql:     "MERGE INTO users AS target USING (VALUES (@p1, @p2)) AS source (id, name) ON target.id = source.id WHEN MATCHED THEN UPDATE SET name = source.name WHEN NOT MATCHED THEN INSERT (id, name) VALUES (source.id, source.name);",
+			dialect: SQLServer,
+			usage: &Usage{
+				Reads:  []TableUsage{{Table: "users", Columns: []string{"id"}}},
+				Writes: []TableUsage{{Table: "users", Columns: []string{"id", "name"}}},
+			},
+		},
+		{
+			name: "update",
+			sql:  "UPDATE users SET name = ?, updated_at = now() WHERE id = ?",
+			usage: &Usage{
+				Reads:  []TableUsage{{Table: "users", Columns: []string{"id"}}},
+				Writes: []TableUsage{{Table: "users", Columns: []string{"name", "updated_at"}}},
+			},
+		},
+		{
+			name: "delete with subquery",
+			sql:  "DELETE FROM sessions WHERE user_id IN (SELECT u.id FROM users u WHERE u.banned)",
+			usage: &Usage{
+				Reads:  []TableUsage{{Table: "users", Columns: []string{"banned", "id"}}},
+				Writes: []TableUsage{{Table: "sessions"}},
+			},
+		},
+		{
+			name: "locking select",
+			sql:  "SELECT balance FROM accounts WHERE id = ? FOR UPDATE",
+			usage: &Usage{Reads: []TableUsage{
+				{Table: "accounts", Columns: []string{"balance", "id"}},
+			}},
+		},
+		{
+			name: "script",
+			sql:  "CREATE TABLE t (id int); INSERT INTO t (id) VALUES (1);",
+			usage: &Usage{
+				Writes: []TableUsage{{Table: "t", Columns: []string{"id"}}},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.usage, AnalyzeUsage(tt.sql, tt.dialect))
+		})
+	}
+}
+
+func TestQueriesUsing(t *testing.T) {
+	square := &SquareSql{}
+	square.Replace(map[string]string{
+		"get-user":    "SELECT id, name FROM users WHERE id = ?",
+		"rename-user": "UPDATE public.users SET name = ? WHERE id = ?",
+		"list-orders": "SELECT o.id FROM orders o JOIN users u ON u.id = o.user_id",
+		"count-all":   "SELECT count(*) FROM orders",
+		"find-order":  "SELECT total FROM {{schema}}.orders WHERE id = ?",
+	})
+
+	assert.Equal(t, []string{"get-user", "list-orders", "rename-user"}, square.QueriesUsing("users", ""))
+	assert.Equal(t, []string{"get-user", "rename-user"}, square.QueriesUsing("USERS", "name"))
+	assert.Equal(t, []string{"list-orders"}, square.QueriesUsing("orders", "user_id"))
+	assert.Equal(t, []string{"find-order", "list-orders"}, square.QueriesUsing("orders", "id"))
+	assert.Equal(t, []string{"find-order"}, square.QueriesUsing("schema.orders", "total"))
+	assert.Empty(t, square.QueriesUsing("public.orders", ""))
+}
+
+func TestUsageOfUpserts(t *testing.T) {
+	square, err := LoadFromString(`
+	-- name: save-user
+	-- upsert: key=id update=name
+	INSERT INTO users (id, name, email) VALUES (?, ?, ?)
+	`, WithDialect(SQLServer))
+	assert.NoError(t, err)
+
+	assert.Equal(t, &Usage{
+		Reads:  []TableUsage{{Table: "users", Columns: []string{"id"}}},
+		Writes: []TableUsage{{Table: "users", Columns: []string{"email", "id", "name"}}},
+	}, square.Usage()["save-user"])
+
+	square.SetDialect(Postgres)
+	assert.Equal(t, &Usage{
+		Reads:  []TableUsage{{Table: "users", Columns: []string{"id"}}},
+		Writes: []TableUsage{{Table: "users", Columns: []string{"email", "id", "name"}}},
+	}, square.Usage()["save-user"])
+}
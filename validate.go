@@ -0,0 +1,110 @@
+package squaresql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// QueryError reports a named query that failed validation.
+type QueryError struct {
+	Name string
+	// File and Line locate the name tag of the query, if it was loaded
+	// from a file.
+	File string
+	Line int
+	// Index is the zero-based position of the failed statement of a query
+	// made of several statements. Statement is empty when the query failed
+	// before any of its statements was prepared, such as for an invalid
+	// annotation.
+	Index     int
+	Statement string
+	Err       error
+}
+
+func (e *QueryError) Error() string {
+	location := e.Name
+	if len(e.File) > 0 {
+		location = fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Name)
+	}
+	if len(e.Statement) == 0 {
+		return fmt.Sprintf("%s: %v", location, e.Err)
+	}
+	return fmt.Sprintf("%s: statement %d: %v", location, e.Index+1, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError collects every query that failed validation.
+type ValidationError struct {
+	Errors []*QueryError
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		lines[i] = "  " + err.Error()
+	}
+	return fmt.Sprintf("squaresql: %d invalid queries:\n%s", len(e.Errors), strings.Join(lines, "\n"))
+}
+
+// Validate prepares every statement of every query, resolved for the
+// configured dialect, against db without executing it, so that queries
+// referring to tables or columns the database does not have are detected
+// at startup. Failures are collected into a *ValidationError.
+//
+// Statements of a script are prepared on their own, so a statement using an
+// object created by an earlier statement of the same script fails; queries
+// annotated with `-- validate: false` are skipped.
+func (s *SquareSql) Validate(ctx context.Context, db PreparerContext) error {
+	sets, dialect := s.snapshot()
+
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failures []*QueryError
+	for _, name := range names {
+		q, ok := sets[name].resolve(dialect)
+		if !ok {
+			continue
+		}
+		validate, set, err := q.flag("validate")
+		if err == nil {
+			err = q.err
+		}
+		query := q.text()
+		if err == nil {
+			query, err = s.render(ctx, q)
+		}
+		if err != nil {
+			failures = append(failures, &QueryError{Name: name, File: q.File, Line: q.Line, Err: err})
+			continue
+		}
+		if set && !validate {
+			continue
+		}
+
+		for i, statement := range SplitStatements(query, dialect) {
+			stmt, err := db.PrepareContext(ctx, statement)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				failures = append(failures, &QueryError{Name: name, File: q.File, Line: q.Line, Index: i, Statement: statement, Err: err})
+				continue
+			}
+			stmt.Close()
+		}
+	}
+
+	if len(failures) > 0 {
+		return &ValidationError{Errors: failures}
+	}
+	return nil
+}
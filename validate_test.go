@@ -0,0 +1,84 @@
+package squaresql_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/allapospelova/squaresql"
+	"github.com/allapospelova/squaresql/squaresqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	square, err := squaresql.LoadFromString(`
+	-- name: get-user
+	SELECT id, name FROM users WHERE id = ?
+
+	-- name: migrate
+	CREATE TABLE t (id int);
+	INSERT INTO t VALUES (1);
+
+	-- name: rename-user
+	UPDATE users SET nickname = ? WHERE id = ?
+
+	-- name: seed
+	-- validate: false
+	INSERT INTO missing VALUES (1)
+	`)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+
+	t.Run("valid", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		mock.ExpectPrepare("get-user")
+		mock.ExpectPrepareSQL("CREATE TABLE t (id int)")
+		mock.ExpectPrepareSQL("INSERT INTO t VALUES (1)")
+		mock.ExpectPrepare("rename-user")
+
+		assert.NoError(t, square.Validate(ctx, db))
+	})
+
+	t.Run("collects failures", func(t *testing.T) {
+		db, mock := squaresqltest.New(t, square)
+		noTable := errors.New("no such table: t")
+		noColumn := errors.New("no such column: nickname")
+		mock.ExpectPrepare("get-user")
+		mock.ExpectPrepareSQL("CREATE TABLE t (id int)")
+		mock.ExpectPrepareSQL("INSERT INTO t VALUES (1)").WillReturnError(noTable)
+		mock.ExpectPrepare("rename-user").WillReturnError(noColumn)
+
+		err := square.Validate(ctx, db)
+		var validationErr *squaresql.ValidationError
+		if !assert.True(t, errors.As(err, &validationErr)) {
+			return
+		}
+		if assert.Len(t, validationErr.Errors, 2) {
+			assert.Equal(t, "migrate", validationErr.Errors[0].Name)
+			assert.Equal(t, 1, validationErr.Errors[0].Index)
+			assert.Equal(t, 9, validationErr.Errors[1].Line)
+			assert.Equal(t, noColumn, validationErr.Errors[1].Err)
+		}
+		assert.Contains(t, err.Error(), "rename-user: statement 1: no such column: nickname")
+	})
+
+	t.Run("queries failing before preparing", func(t *testing.T) {
+		square, err := squaresql.LoadFromString(`
+		-- name: broken
+		-- validate: maybe
+		SELECT 1
+		`)
+		assert.NoError(t, err)
+		db, _ := squaresqltest.New(t, square)
+
+		err = square.Validate(ctx, db)
+		var validationErr *squaresql.ValidationError
+		if assert.True(t, errors.As(err, &validationErr)) && assert.Len(t, validationErr.Errors, 1) {
+			assert.Empty(t, validationErr.Errors[0].Statement)
+			assert.NotContains(t, validationErr.Errors[0].Error(), "statement")
+			assert.True(t, strings.HasPrefix(validationErr.Errors[0].Error(), "broken: "), validationErr.Errors[0].Error())
+		}
+	})
+}
@@ -0,0 +1,283 @@
+package squaresql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlString renders s as a double-quoted YAML scalar.
+func yamlString(s string) string {
+	return strconv.Quote(s)
+}
+
+// yamlBlock renders s as a literal block scalar indented by indent, falling
+// back to a quoted scalar for text a block scalar cannot represent as is:
+// lines starting with a tab or made up of whitespace only would not be read
+// back verbatim.
+func yamlBlock(s, indent string) string {
+	if !strings.Contains(s, "\n") || strings.HasPrefix(s, " ") || strings.HasSuffix(s, "\n") || strings.Contains(s, "\r") {
+		return yamlString(s)
+	}
+
+	lines := strings.Split(s, "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "\t") || len(line) > 0 && len(strings.TrimSpace(line)) == 0 {
+			return yamlString(s)
+		}
+	}
+	for i, line := range lines {
+		if len(line) > 0 {
+			lines[i] = indent + line
+		}
+	}
+	return "|-\n" + strings.Join(lines, "\n")
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+	raw    string
+}
+
+type yamlParser struct {
+	lines []*yamlLine
+	pos   int
+}
+
+// parseYAML parses a document into nested map[string]interface{},
+// []interface{} and string values. Empty values are nil.
+func parseYAML(doc string) (interface{}, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(strings.Replace(doc, "\r\n", "\n", -1), "\n") {
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		p.lines = append(p.lines, &yamlLine{num: i + 1, indent: len(raw) - len(text), text: strings.TrimRight(text, " \t"), raw: raw})
+	}
+
+	line := p.next()
+	if line == nil {
+		return nil, nil
+	}
+	if line.text == "---" {
+		p.pos++
+		if line = p.next(); line == nil {
+			return nil, nil
+		}
+	}
+
+	node, err := p.node(line.indent)
+	if err != nil {
+		return nil, err
+	}
+	if line := p.next(); line != nil {
+		return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+	}
+
+	return node, nil
+}
+
+// next returns the next line that is neither blank nor a comment.
+func (p *yamlParser) next() *yamlLine {
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if len(line.text) > 0 && !strings.HasPrefix(line.text, "#") {
+			return line
+		}
+		p.pos++
+	}
+	return nil
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) node(indent int) (interface{}, error) {
+	line := p.next()
+	if isSequenceItem(line.text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitKey(line.text); ok {
+		return p.mapping(indent)
+	}
+
+	p.pos++
+	return scalar(line.text, line.num)
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for {
+		line := p.next()
+		if line == nil || line.indent != indent || !isSequenceItem(line.text) {
+			return items, nil
+		}
+
+		content := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if len(content) == 0 {
+			p.pos++
+			item, err := p.child(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		// Re-read the item's content as if it started on its own line.
+		line.indent += len(line.text) - len(content)
+		line.text = content
+		item, err := p.node(line.indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	fields := make(map[string]interface{})
+	for {
+		line := p.next()
+		if line == nil || line.indent != indent || isSequenceItem(line.text) {
+			return fields, nil
+		}
+
+		key, rest, ok := splitKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected a key", line.num)
+		}
+		if _, dup := fields[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.num, key)
+		}
+		p.pos++
+
+		var (
+			value interface{}
+			err   error
+		)
+		switch {
+		case strings.HasPrefix(rest, "|"):
+			value, err = p.block(rest, indent, line.num)
+		case strings.HasPrefix(rest, ">"):
+			err = fmt.Errorf("line %d: folded block scalars are not supported", line.num)
+		case len(rest) > 0:
+			value, err = scalar(rest, line.num)
+		default:
+			if next := p.next(); next != nil && next.indent == indent && isSequenceItem(next.text) {
+				value, err = p.sequence(indent)
+			} else {
+				value, err = p.child(indent)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = value
+	}
+}
+
+// child parses the node nested under a line with the given indent, if any.
+func (p *yamlParser) child(indent int) (interface{}, error) {
+	next := p.next()
+	if next == nil || next.indent <= indent {
+		return nil, nil
+	}
+	return p.node(next.indent)
+}
+
+// block reads a literal block scalar following a `|` header.
+func (p *yamlParser) block(header string, indent, num int) (interface{}, error) {
+	chomp := byte(0)
+	contentIndent := 0
+	for _, c := range header[1:] {
+		switch {
+		case c == '-' || c == '+':
+			chomp = byte(c)
+		case c >= '1' && c <= '9':
+			contentIndent = indent + int(c-'0')
+		case c == ' ':
+		default:
+			return nil, fmt.Errorf("line %d: invalid block scalar header %q", num, header)
+		}
+	}
+
+	var lines []string
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		if len(strings.TrimSpace(line.raw)) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		if contentIndent == 0 {
+			if line.indent <= indent {
+				break
+			}
+			contentIndent = line.indent
+		}
+		if line.indent < contentIndent {
+			break
+		}
+		lines = append(lines, line.raw[contentIndent:])
+	}
+
+	if chomp != '+' {
+		for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+			lines = lines[:len(lines)-1]
+		}
+	}
+	text := strings.Join(lines, "\n")
+	if chomp != '-' && len(lines) > 0 {
+		text += "\n"
+	}
+
+	return text, nil
+}
+
+// splitKey splits a `key: value` line. Keys must be plain scalars.
+func splitKey(text string) (key, rest string, ok bool) {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		return "", "", false
+	}
+
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", false
+		}
+		i = len(text) - 1
+	}
+
+	return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+}
+
+func scalar(text string, num int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "\""):
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid double-quoted scalar %s", num, text)
+		}
+		return s, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("line %d: invalid single-quoted scalar %s", num, text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	case text == "[]":
+		return []interface{}{}, nil
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case text == "~" || text == "null":
+		return nil, nil
+	}
+
+	if i := strings.Index(text, " #"); i >= 0 {
+		text = strings.TrimSpace(text[:i])
+	}
+	return text, nil
+}